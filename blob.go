@@ -0,0 +1,20 @@
+package app
+
+import (
+	"context"
+	"io"
+)
+
+// BlobStore is an interface for keeping files, like item images,
+// out of the database. Keys are slash-separated paths, e.g. "images/1a2b.jpg"
+type BlobStore interface {
+	// Put stores size bytes read from r under a key,
+	// replacing the blob already there if any
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the blob under a key, callers must close it.
+	// If not found, return ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob under a key.
+	// Deleting a blob that does not exist is not an error
+	Delete(ctx context.Context, key string) error
+}
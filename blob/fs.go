@@ -0,0 +1,104 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	app "useritem"
+)
+
+// FSStore is a local filesystem implementation of app.BlobStore.
+// Blobs are files under Dir, at the path of their key
+type FSStore struct {
+	Dir string
+}
+
+// Put writes a blob to a temporary file, then moves it
+// in place so readers never see half-written blobs
+// return an error
+func (s *FSStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(name), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("blob: wrote %d bytes of %s, want %d", n, key, size)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// Get opens the file of a blob
+// return an io.ReadCloser and an error
+// if not found, return app.ErrNotFound
+func (s *FSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, app.ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes the file of a blob
+// return an error
+func (s *FSStore) Delete(ctx context.Context, key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Check makes sure blobs can be written to Dir
+// return an error
+func (s *FSStore) Check(ctx context.Context) error {
+	err := os.MkdirAll(s.Dir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.Dir, ".check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// path returns the file of a key, refusing keys
+// that would lead out of Dir
+func (s *FSStore) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("blob: invalid key %q", key)
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,76 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	app "useritem"
+)
+
+func TestFSStorePath(t *testing.T) {
+	s := &FSStore{Dir: "/var/blobs"}
+	for _, key := range []string{
+		"", ".", "..", "../x", "images/../../x", "/etc/passwd", "images/", `images\..\x`, "./images/a.jpg",
+	} {
+		name, err := s.path(key)
+		if err == nil {
+			t.Errorf("path(%q) = %q, want an error", key, name)
+		}
+	}
+	name, err := s.path("images/1a2b.jpg")
+	if want := filepath.Join("/var/blobs", "images", "1a2b.jpg"); err != nil || name != want {
+		t.Errorf("path(images/1a2b.jpg) = %q, %v, want %q", name, err, want)
+	}
+}
+
+func TestFSStore(t *testing.T) {
+	s := &FSStore{Dir: filepath.Join(t.TempDir(), "blobs")}
+	ctx := context.Background()
+
+	err := s.Check(ctx)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	err = s.Put(ctx, "images/a.jpg", strings.NewReader("image"), 5, "image/jpeg")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	err = s.Put(ctx, "images/b.jpg", strings.NewReader("image"), 6, "image/jpeg")
+	if err == nil {
+		t.Error("Put with the wrong size succeeded")
+	}
+	entries, _ := os.ReadDir(filepath.Join(s.Dir, "images"))
+	if len(entries) != 1 {
+		t.Errorf("Put left %d files, want 1", len(entries))
+	}
+
+	rc, err := s.Get(ctx, "images/a.jpg")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	body, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(body) != "image" {
+		t.Errorf("Get = %q, %v, want %q", body, err, "image")
+	}
+
+	err = s.Delete(ctx, "images/a.jpg")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	err = s.Delete(ctx, "images/a.jpg")
+	if err != nil {
+		t.Errorf("Delete missing = %v, want nil", err)
+	}
+	_, err = s.Get(ctx, "images/a.jpg")
+	if err != app.ErrNotFound {
+		t.Errorf("Get deleted = %v, want app.ErrNotFound", err)
+	}
+	err = s.Put(ctx, "../escape.jpg", strings.NewReader("image"), 5, "image/jpeg")
+	if err == nil {
+		t.Error("Put ../escape.jpg succeeded")
+	}
+}
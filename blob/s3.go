@@ -0,0 +1,228 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	app "useritem"
+)
+
+// unsignedPayload stands for the hash of request bodies
+// so that blobs are streamed instead of read twice
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Store is an implementation of app.BlobStore for Amazon S3
+// and the services speaking its API, like MinIO.
+// Blobs are objects of Bucket, named after their key
+type S3Store struct {
+	// Endpoint is the base URL of the service,
+	// e.g. "https://s3.eu-west-1.amazonaws.com" or "http://localhost:9000"
+	Endpoint string
+	Region   string
+	Bucket   string
+	// AccessKeyID and SecretAccessKey sign requests
+	AccessKeyID     string
+	SecretAccessKey string
+	// VirtualHosted puts the bucket in the host name,
+	// e.g. bucket.s3.eu-west-1.amazonaws.com, instead of the path.
+	// Local stand-ins usually only support paths
+	VirtualHosted bool
+	// Client is optional, http.DefaultClient is used when nil
+	Client *http.Client
+}
+
+// Put uploads a blob as an object
+// return an error
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if size == 0 {
+		r = http.NoBody
+	}
+	req, err := s.request(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	res, err := s.send(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Get downloads the object of a blob
+// return an io.ReadCloser and an error
+// if not found, return app.ErrNotFound
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.send(req)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Delete removes the object of a blob
+// return an error
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.send(req)
+	if err == app.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Check makes sure the bucket exists and can be reached
+// return an error
+func (s *S3Store) Check(ctx context.Context) error {
+	req, err := s.request(ctx, http.MethodHead, "", nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.send(req)
+	if err == app.ErrNotFound {
+		return fmt.Errorf("blob: bucket %s does not exist", s.Bucket)
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// request builds a request on the object of a key,
+// or on the bucket itself if key is empty
+func (s *S3Store) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	p := "/" + key
+	if s.VirtualHosted {
+		u.Host = s.Bucket + "." + u.Host
+	} else if key == "" {
+		p = "/" + s.Bucket
+	} else {
+		p = "/" + s.Bucket + p
+	}
+	u.Path = p
+	u.RawPath = escapePath(p)
+	return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+// send signs a request and sends it
+// return the response and an error
+// if the object or bucket is not found, return app.ErrNotFound
+func (s *S3Store) send(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, app.ErrNotFound
+	}
+	// S3 tells what is wrong in an XML body, except for HEAD requests
+	var e struct {
+		Code    string
+		Message string
+	}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	msg := res.Status
+	if xml.Unmarshal(body, &e) == nil {
+		msg += ": " + e.Code + " " + e.Message
+	}
+	return nil, fmt.Errorf("blob: s3 %s %s: %s", req.Method, req.URL.Path, msg)
+}
+
+// sign adds an AWS Signature Version 4 to a request, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3Store) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + unsignedPayload,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	signature := s.signature(amzDate, canonicalRequest)
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// signature signs the canonical form of a request made at amzDate
+func (s *S3Store) signature(amzDate, canonicalRequest string) string {
+	date := amzDate[:8]
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath percent-encodes a path the way S3 signatures want:
+// everything but unreserved characters and slashes
+func escapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
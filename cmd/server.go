@@ -1,32 +1,273 @@
-package main
-
-import (
-	"database/sql"
-	"log"
-
-	"useritem/http"
-	"useritem/sqlite"
-
-	_ "github.com/mattn/go-sqlite3"
-)
-
-func main() {
-	// setup db connection
-	db, err := sql.Open("sqlite3", "database.db")
-	if err != nil {
-		log.Panic(err)
-	}
-	defer db.Close()
-	err = db.Ping()
-	if err != nil {
-		log.Panic(err)
-	}
-
-	// setup repos
-	userRepo := &sqlite.UserRepo{DB: db}
-	itemRepo := &sqlite.ItemRepo{DB: db}
-
-	// setup server
-	server := http.NewServer(userRepo, itemRepo)
-	log.Fatal(http.ListenAndServe(":8080", server))
-}
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	app "useritem"
+	"useritem/blob"
+	"useritem/http"
+	"useritem/mail"
+	"useritem/metrics"
+	"useritem/sqlite"
+	"useritem/tracing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Set at link time, e.g.
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD)"
+//
+// When not set, commit and build time come from the VCS info
+// the go command stamps into binaries
+var (
+	version   = "dev"
+	commit    string
+	buildTime string
+)
+
+// buildInfo describes this binary
+func buildInfo() http.BuildInfo {
+	info := http.BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// splitList splits a comma-separated flag value
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	dsn := flag.String("db", "database.db", "sqlite database file")
+	baseURL := flag.String("base-url", "http://localhost:8080", "public address of the server, used in mailed links")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server host:port. If empty, mails are written to -mail-log instead")
+	smtpUser := flag.String("smtp-user", "", "SMTP username, the password is read from $SMTP_PASSWORD")
+	smtpFrom := flag.String("smtp-from", "no-reply@localhost", "sender address of mails")
+	mailLog := flag.String("mail-log", "", "file to write mails to when no SMTP server is set. Defaults to stderr")
+	blobDir := flag.String("blob-dir", "blobs", "directory to keep item images in when no S3 bucket is set")
+	s3Endpoint := flag.String("s3-endpoint", "https://s3.amazonaws.com", "base URL of the S3 API, e.g. http://localhost:9000 for a local MinIO")
+	s3Region := flag.String("s3-region", "us-east-1", "region of the S3 bucket")
+	s3Bucket := flag.String("s3-bucket", "", "S3 bucket to keep item images in, credentials are read from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+	s3VirtualHosted := flag.Bool("s3-virtual-hosted", false, "address the S3 bucket in the host name rather than in the path")
+	grantAdmin := flag.String("grant-admin", "", "give the admin role to the user with this email, then exit")
+	requireVerifiedEmail := flag.Bool("require-verified-email", false, "forbid users to create items until they verify their email")
+	maxBatchSize := flag.Int("max-batch-size", 100, "how many items batch requests of the JSON API can act on")
+	logFormat := flag.String("log-format", "text", "log format, text or json")
+	traceOTLP := flag.String("trace-otlp", "", "host:port of an OTLP/HTTP collector to send traces to, e.g. localhost:4318")
+	traceOTLPInsecure := flag.Bool("trace-otlp-insecure", false, "connect to the OTLP collector without TLS")
+	traceFile := flag.String("trace-file", "", "file to write traces to as OTLP/JSON")
+	corsOrigins := flag.String("cors-origins", "", "comma-separated origins allowed to call the JSON API from a browser, e.g. https://*.example.com")
+	corsHeaders := flag.String("cors-headers", "Authorization,Content-Type", "comma-separated request headers allowed cross-origin")
+	corsCredentials := flag.Bool("cors-credentials", false, "allow cross-origin requests with credentials")
+	corsMaxAge := flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache preflight responses")
+	apiV1Deprecation := flag.String("api-v1-deprecation", http.DefaultAPIv1Deprecation, "date /api/v1 is deprecated since. Announced in the Deprecation header of its responses")
+	apiV1Sunset := flag.String("api-v1-sunset", "", "date, like 2027-04-30, when /api/v1 goes away. Announced in the Sunset header of its responses")
+	templatesDir := flag.String("templates-dir", "", "development only: load HTML templates from this directory, e.g. http/templates, and reload them on each request")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	flag.Parse()
+
+	// setup logger
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatal(err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch *logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		log.Fatalf("unknown log format %q", *logFormat)
+	}
+
+	// setup tracing
+	traceCfg := tracing.Config{
+		ServiceName:  "useritem",
+		OTLPEndpoint: *traceOTLP,
+		OTLPInsecure: *traceOTLPInsecure,
+	}
+	if *traceFile != "" {
+		f, err := os.OpenFile(*traceFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Panic(err)
+		}
+		defer f.Close()
+		traceCfg.W = f
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), traceCfg)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// setup db connection
+	db, err := sql.Open("sqlite3", *dsn)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer db.Close()
+	err = db.Ping()
+	if err != nil {
+		log.Panic(err)
+	}
+	err = sqlite.Migrate(db)
+	if err != nil {
+		log.Panic(err)
+	}
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "sqlite"))
+
+	// setup repos
+	userRepo := &metrics.UserRepo{Next: &sqlite.UserRepo{DB: db}}
+	itemRepo := &metrics.ItemRepo{Next: &sqlite.ItemRepo{DB: db}}
+	tokenRepo := &sqlite.TokenRepo{DB: db}
+	recoveryCodeRepo := &sqlite.RecoveryCodeRepo{DB: db}
+	accessTokenRepo := &sqlite.AccessTokenRepo{DB: db}
+
+	if *grantAdmin != "" {
+		user, err := userRepo.ByEmail(context.Background(), *grantAdmin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = userRepo.UpdateRole(context.Background(), user.ID, app.RoleAdmin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s is now an admin", user.Email)
+		return
+	}
+
+	// setup mailer
+	var mailer app.Mailer
+	if *smtpAddr != "" {
+		m := &mail.SMTPMailer{Addr: *smtpAddr, From: *smtpFrom}
+		if *smtpUser != "" {
+			host, _, err := net.SplitHostPort(*smtpAddr)
+			if err != nil {
+				log.Panic(err)
+			}
+			m.Auth = smtp.PlainAuth("", *smtpUser, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		mailer = m
+	} else {
+		w := os.Stderr
+		if *mailLog != "" {
+			w, err = os.OpenFile(*mailLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				log.Panic(err)
+			}
+			defer w.Close()
+		}
+		mailer = &mail.LogMailer{W: w}
+	}
+
+	// setup blob store
+	var blobStore interface {
+		app.BlobStore
+		app.HealthChecker
+	}
+	if *s3Bucket != "" {
+		blobStore = &blob.S3Store{
+			Endpoint:        *s3Endpoint,
+			Region:          *s3Region,
+			Bucket:          *s3Bucket,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			VirtualHosted:   *s3VirtualHosted,
+		}
+	} else {
+		blobStore = &blob.FSStore{Dir: *blobDir}
+	}
+
+	// setup API versions
+	apiDeprecation := make(map[http.APIVersion]time.Time)
+	apiDeprecation[http.APIv1], err = time.Parse("2006-01-02", *apiV1Deprecation)
+	if err != nil {
+		log.Panic(err)
+	}
+	apiSunset := make(map[http.APIVersion]time.Time)
+	if *apiV1Sunset != "" {
+		apiSunset[http.APIv1], err = time.Parse("2006-01-02", *apiV1Sunset)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	// setup probes
+	health := http.NewHealthHandler(buildInfo())
+	health.Register("db", &sqlite.HealthChecker{DB: db})
+	health.Register("blobs", blobStore)
+
+	// setup server
+	server := http.NewServer(http.Config{
+		UserRepo:  userRepo,
+		ItemRepo:  itemRepo,
+		TokenRepo: tokenRepo,
+		Mailer:    mailer,
+		BlobStore: &metrics.BlobStore{Next: blobStore},
+		BaseURL:   *baseURL,
+
+		RecoveryCodeRepo: recoveryCodeRepo,
+		AccessTokenRepo:  accessTokenRepo,
+
+		Health:         health,
+		APIDeprecation: apiDeprecation,
+		APISunset:      apiSunset,
+		CORS: http.CORSConfig{
+			AllowedOrigins:   splitList(*corsOrigins),
+			AllowedHeaders:   splitList(*corsHeaders),
+			ExposedHeaders:   []string{"X-Request-ID", "ETag", "Deprecation", "Sunset", "Link"},
+			AllowCredentials: *corsCredentials,
+			MaxAge:           *corsMaxAge,
+		},
+
+		TemplatesDir:         *templatesDir,
+		RequireVerifiedEmail: *requireVerifiedEmail,
+		MaxBatchSize:         *maxBatchSize,
+	})
+
+	// serve until interrupted, then flush what is pending
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = http.ListenAndServeContext(ctx, *addr, server)
+	if err != nil {
+		log.Panic(err)
+	}
+}
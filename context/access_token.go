@@ -0,0 +1,35 @@
+package context
+
+import (
+	"context"
+	"fmt"
+	app "useritem"
+)
+
+const (
+	accessTokenKey contextKey = "access_token"
+)
+
+// WithAccessToken derives a new context with the access token
+// a request was authenticated with
+func WithAccessToken(ctx context.Context, token *app.AccessToken) context.Context {
+	return context.WithValue(ctx, accessTokenKey, token)
+}
+
+// AccessToken retrieves an access token from context.
+// It is nil when the request was authenticated with a session.
+// return an error if the context holds something else,
+// which is a bug
+func AccessToken(ctx context.Context) (*app.AccessToken, error) {
+	tmp := ctx.Value(accessTokenKey)
+	if tmp == nil {
+		// access token not found
+		return nil, nil
+	}
+	token, ok := tmp.(*app.AccessToken)
+	if !ok {
+		// value is not an access token
+		return nil, fmt.Errorf("context: access token value set incorrectly. type=%T, value=%#v", tmp, tmp)
+	}
+	return token, nil
+}
@@ -0,0 +1,81 @@
+package context
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+const (
+	loggerKey    contextKey = "logger"
+	requestIDKey contextKey = "request_id"
+)
+
+// requestLogger holds the logger of a request.
+// It is shared by pointer so that fields added deep in the handler chain,
+// like the user id, also show up in logs written by outer middlewares
+type requestLogger struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+}
+
+// WithLogger derives a new context with a request logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, &requestLogger{logger: logger})
+}
+
+// Logger retrieves the request logger from context,
+// with every field added so far.
+// If there is none, the default logger is returned
+func Logger(ctx context.Context) *slog.Logger {
+	rl := requestLoggerFrom(ctx)
+	if rl == nil {
+		return slog.Default()
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.logger
+}
+
+// AddLogFields adds fields to the request logger in context.
+// Fields are seen by everyone holding the request's context,
+// including the middlewares that derived it
+func AddLogFields(ctx context.Context, args ...any) {
+	rl := requestLoggerFrom(ctx)
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.logger = rl.logger.With(args...)
+}
+
+// requestLoggerFrom retrieves the request logger holder from context.
+// A value of the wrong type is a bug: it is reported
+// with the default logger and ignored
+func requestLoggerFrom(ctx context.Context) *requestLogger {
+	tmp := ctx.Value(loggerKey)
+	if tmp == nil {
+		// logger not found
+		return nil
+	}
+	rl, ok := tmp.(*requestLogger)
+	if !ok {
+		// value is not a logger
+		slog.Error("context: logger value set incorrectly", "type", fmt.Sprintf("%T", tmp))
+		return nil
+	}
+	return rl
+}
+
+// WithRequestID derives a new context with a request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID retrieves the request id from context
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
@@ -0,0 +1,47 @@
+package context
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	routeKey contextKey = "route"
+)
+
+// route holds the route template a request matched.
+// The router sets it once it matched the request,
+// which is after outer middlewares derived their context,
+// so it is shared by pointer
+type route struct {
+	mu       sync.Mutex
+	template string
+}
+
+// WithRoute derives a new context able to hold a route template
+func WithRoute(ctx context.Context) context.Context {
+	return context.WithValue(ctx, routeKey, &route{})
+}
+
+// SetRoute records the route template a request matched
+func SetRoute(ctx context.Context, template string) {
+	rt, ok := ctx.Value(routeKey).(*route)
+	if !ok {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.template = template
+}
+
+// Route retrieves the route template a request matched.
+// It is empty when no route matched
+func Route(ctx context.Context) string {
+	rt, ok := ctx.Value(routeKey).(*route)
+	if !ok {
+		return ""
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.template
+}
@@ -1,35 +1,40 @@
-package context
-
-import (
-	"context"
-	"log"
-	app "useritem"
-)
-
-type contextKey string
-
-const (
-	userKey contextKey = "user"
-)
-
-// WithUser derives a new context with an user
-func WithUser(ctx context.Context, user *app.User) context.Context {
-	return context.WithValue(ctx, userKey, user)
-}
-
-// User retrieves an user from context
-func User(ctx context.Context) *app.User {
-	tmp := ctx.Value(userKey)
-	if tmp == nil {
-		// user not found
-		return nil
-	}
-	user, ok := tmp.(*app.User)
-	if !ok {
-		// value is not an user
-		// this is a bug
-		log.Fatalf("context: user value set incorrectly. type=%T, value=%#v", tmp, tmp)
-		return nil
-	}
-	return user
-}
+package context
+
+import (
+	"context"
+	"fmt"
+	app "useritem"
+)
+
+type contextKey string
+
+const (
+	userKey contextKey = "user"
+)
+
+// WithUser derives a new context with an user.
+// The user id is added to the request logger fields
+func WithUser(ctx context.Context, user *app.User) context.Context {
+	if user != nil {
+		AddLogFields(ctx, "user_id", user.ID)
+	}
+	return context.WithValue(ctx, userKey, user)
+}
+
+// User retrieves an user from context.
+// It is nil when no user is set.
+// return an error if the context holds something else,
+// which is a bug
+func User(ctx context.Context) (*app.User, error) {
+	tmp := ctx.Value(userKey)
+	if tmp == nil {
+		// user not found
+		return nil, nil
+	}
+	user, ok := tmp.(*app.User)
+	if !ok {
+		// value is not an user
+		return nil, fmt.Errorf("context: user value set incorrectly. type=%T, value=%#v", tmp, tmp)
+	}
+	return user, nil
+}
@@ -0,0 +1,10 @@
+package app
+
+import "context"
+
+// HealthChecker is an interface for dependencies
+// that must be up for the service to be ready to serve
+type HealthChecker interface {
+	// Check returns an error if the dependency is not usable
+	Check(ctx context.Context) error
+}
@@ -0,0 +1,144 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	app "useritem"
+
+	"github.com/gorilla/mux"
+)
+
+// accessTokenPrefix tells access tokens apart from session tokens
+const accessTokenPrefix = "uipat_"
+
+// AccessTokenHandler handles the access tokens of an user
+type AccessTokenHandler struct {
+	accessTokenRepo app.AccessTokenRepo
+}
+
+// accessTokenRenderer parses the requests and renders the responses of AccessTokenHandler
+// in the format of a Renderer
+type accessTokenRenderer struct {
+	renderIndexSuccess func(http.ResponseWriter, *http.Request, []app.AccessToken)
+	renderIndexError   func(http.ResponseWriter, *http.Request, error)
+
+	parseAccessToken    func(*http.Request) (*app.AccessToken, error)
+	renderCreateSuccess func(w http.ResponseWriter, r *http.Request, token *app.AccessToken, secret string)
+	renderCreateError   func(http.ResponseWriter, *http.Request, error)
+
+	renderDeleteSuccess func(http.ResponseWriter, *http.Request)
+	renderDeleteError   func(http.ResponseWriter, *http.Request, error)
+}
+
+// Index shows all access tokens of an user
+func (h *AccessTokenHandler) Index(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).accessTokens
+	user := currentUser(r)
+	tokens, err := h.accessTokenRepo.ByUser(r.Context(), user.ID)
+	if err != nil {
+		logError(r, err)
+		rd.renderIndexError(w, r, err)
+		return
+	}
+	rd.renderIndexSuccess(w, r, tokens)
+}
+
+// Create mints a new access token.
+// The token is shown once, only its hash is stored
+func (h *AccessTokenHandler) Create(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).accessTokens
+	user := currentUser(r)
+
+	// Parse token and validate data
+	token, err := rd.parseAccessToken(r)
+	if err != nil {
+		rd.renderCreateError(w, r, err)
+		return
+	}
+	err = validateAccessToken(token)
+	if err != nil {
+		rd.renderCreateError(w, r, err)
+		return
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		logError(r, err)
+		rd.renderCreateError(w, r, err)
+		return
+	}
+	secret = accessTokenPrefix + secret
+	token.UserID = user.ID
+	token.Hash = hashSecret(secret)
+	token.CreatedAt = time.Now()
+
+	err = h.accessTokenRepo.Create(r.Context(), token)
+	if err != nil {
+		logError(r, err)
+		rd.renderCreateError(w, r, err)
+		return
+	}
+	rd.renderCreateSuccess(w, r, token, secret)
+}
+
+// Delete revokes an access token
+func (h *AccessTokenHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).accessTokens
+	user := currentUser(r)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rd.renderDeleteError(w, r, app.ErrNotFound)
+		return
+	}
+	err = h.accessTokenRepo.Delete(r.Context(), user.ID, id)
+	if err != nil {
+		if err != app.ErrNotFound {
+			logError(r, err)
+		}
+		rd.renderDeleteError(w, r, err)
+		return
+	}
+	rd.renderDeleteSuccess(w, r)
+}
+
+func validateAccessToken(token *app.AccessToken) error {
+	if strings.TrimSpace(token.Name) == "" {
+		return validationError{
+			fields:  []string{"name"},
+			message: "Name is required",
+		}
+	}
+	if len(token.Scopes) == 0 {
+		return validationError{
+			fields:  []string{"scopes"},
+			message: "At least one scope is required",
+		}
+	}
+	for _, scope := range token.Scopes {
+		if !isGrantable(scope) {
+			return validationError{
+				fields:  []string{"scopes"},
+				message: "Unknown scope %s",
+				args:    []interface{}{scope},
+			}
+		}
+	}
+	if !token.ExpiresAt.IsZero() && !token.ExpiresAt.After(time.Now()) {
+		return validationError{
+			fields:  []string{"expires_at"},
+			message: "Expiry must be in the future",
+		}
+	}
+	return nil
+}
+
+func isGrantable(scope app.Scope) bool {
+	for _, s := range app.GrantableScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package http
+
+import (
+	gocontext "context"
+	"net/http"
+	"strconv"
+	app "useritem"
+
+	"github.com/gorilla/mux"
+)
+
+const adminPageSize = 20
+
+// AdminHandler handles the admin console,
+// where staff members help users with their accounts and items
+type AdminHandler struct {
+	userRepo        app.UserRepo
+	itemRepo        app.ItemRepo
+	accessTokenRepo app.AccessTokenRepo
+	linkMailer      *linkMailer
+}
+
+// adminRenderer parses the requests and renders the responses of AdminHandler
+// in the format of a Renderer
+type adminRenderer struct {
+	renderIndex         func(http.ResponseWriter, *http.Request, adminUserList)
+	renderUser          func(w http.ResponseWriter, r *http.Request, user *app.User, items []app.Item)
+	renderActionSuccess func(http.ResponseWriter, *http.Request, *app.User)
+	renderError         func(http.ResponseWriter, *http.Request, error)
+}
+
+// adminUserList is a page of users matching a search
+type adminUserList struct {
+	Query string
+	Users []app.User
+	Page  int
+	// HasNext tells whether there are users after this page
+	HasNext bool
+}
+
+// Index searches users by name or email
+func (h *AdminHandler) Index(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).admin
+	list := adminUserList{
+		Query: r.URL.Query().Get("q"),
+		Page:  1,
+	}
+	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && page > 1 {
+		list.Page = page
+	}
+
+	// Ask one more user than needed to know if there is a next page
+	users, err := h.userRepo.Search(r.Context(), list.Query, adminPageSize+1, (list.Page-1)*adminPageSize)
+	if err != nil {
+		logError(r, err)
+		rd.renderError(w, r, err)
+		return
+	}
+	if len(users) > adminPageSize {
+		users = users[:adminPageSize]
+		list.HasNext = true
+	}
+	list.Users = users
+	rd.renderIndex(w, r, list)
+}
+
+// ShowUser shows an user and their items
+func (h *AdminHandler) ShowUser(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).admin
+	user, err := h.userFromRequest(r)
+	if err != nil {
+		rd.renderError(w, r, err)
+		return
+	}
+	items, err := h.itemRepo.ByUser(r.Context(), user.ID)
+	if err != nil {
+		logError(r, err)
+		rd.renderError(w, r, err)
+		return
+	}
+	rd.renderUser(w, r, user, items)
+}
+
+// ResetPassword mails a password reset link to an user
+func (h *AdminHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).admin
+	user, err := h.userFromRequest(r)
+	if err != nil {
+		rd.renderError(w, r, err)
+		return
+	}
+	err = h.linkMailer.sendPasswordReset(r.Context(), user)
+	if err != nil {
+		logError(r, err)
+		rd.renderError(w, r, err)
+		return
+	}
+	rd.renderActionSuccess(w, r, user)
+}
+
+// RevokeSessions signs an user out everywhere
+// and revokes all their access tokens
+func (h *AdminHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).admin
+	user, err := h.userFromRequest(r)
+	if err != nil {
+		rd.renderError(w, r, err)
+		return
+	}
+	err = h.revokeSessions(r.Context(), user)
+	if err != nil {
+		logError(r, err)
+		rd.renderError(w, r, err)
+		return
+	}
+	rd.renderActionSuccess(w, r, user)
+}
+
+// Disable disables an user account and signs them out everywhere.
+// Admins cannot disable themselves
+func (h *AdminHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).admin
+	user, err := h.userFromRequest(r)
+	if err != nil {
+		rd.renderError(w, r, err)
+		return
+	}
+	if user.ID == currentUser(r).ID {
+		rd.renderError(w, r, errForbidden)
+		return
+	}
+	err = h.userRepo.UpdateDisabled(r.Context(), user.ID, true)
+	if err == nil {
+		err = h.revokeSessions(r.Context(), user)
+	}
+	if err != nil {
+		logError(r, err)
+		rd.renderError(w, r, err)
+		return
+	}
+	user.Disabled = true
+	rd.renderActionSuccess(w, r, user)
+}
+
+// Enable enables a disabled user account
+func (h *AdminHandler) Enable(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).admin
+	user, err := h.userFromRequest(r)
+	if err != nil {
+		rd.renderError(w, r, err)
+		return
+	}
+	err = h.userRepo.UpdateDisabled(r.Context(), user.ID, false)
+	if err != nil {
+		logError(r, err)
+		rd.renderError(w, r, err)
+		return
+	}
+	user.Disabled = false
+	rd.renderActionSuccess(w, r, user)
+}
+
+// userFromRequest looks up the user whose id is in the URL
+func (h *AdminHandler) userFromRequest(r *http.Request) (*app.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, app.ErrNotFound
+	}
+	user, err := h.userRepo.ByID(r.Context(), id)
+	if err != nil && err != app.ErrNotFound {
+		logError(r, err)
+	}
+	return user, err
+}
+
+// revokeSessions replaces the session token of an user by one nobody knows
+// and deletes their access tokens
+func (h *AdminHandler) revokeSessions(ctx gocontext.Context, user *app.User) error {
+	token, err := newSessionToken()
+	if err != nil {
+		return err
+	}
+	err = h.userRepo.UpdateToken(ctx, user.ID, token)
+	if err != nil {
+		return err
+	}
+	return h.accessTokenRepo.DeleteByUser(ctx, user.ID)
+}
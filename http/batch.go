@@ -0,0 +1,200 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	app "useritem"
+)
+
+const (
+	// defaultMaxBatchSize is how many items a batch can act on
+	// when Config.MaxBatchSize is not set
+	defaultMaxBatchSize = 100
+	// maxBatchItemSize is the room an item has in the body of a batch, in bytes
+	maxBatchItemSize = 8 << 10
+)
+
+var errDuplicateInBatch = validationError{
+	fields:  []string{"id"},
+	message: "The item is already in the batch",
+}
+
+// batchResult is the outcome of the operation of a batch on an item
+type batchResult struct {
+	// Status is the HTTP status of the operation, when it succeeded
+	Status int
+	// ID is the id of the item acted on, 0 for items not created
+	ID int
+	// Item is the item created or updated
+	Item *app.Item
+	Err  error
+}
+
+// BatchCreate creates items for the current user.
+// Items are checked like on Create, and the valid ones
+// are all created in a single transaction
+func (h *ItemHandler) BatchCreate(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	user := currentUser(r)
+	if h.requireVerifiedEmail && !user.EmailVerified {
+		rd.renderBatchError(w, r, errEmailNotVerified)
+		return
+	}
+	h.limitBatch(w, r)
+	items, err := rd.parseBatch(r)
+	err = h.checkBatchSize(len(items), err)
+	if err != nil {
+		rd.renderBatchError(w, r, err)
+		return
+	}
+
+	results := make([]batchResult, len(items))
+	var valid []app.Item
+	var indexes []int
+	for i, item := range items {
+		item.ID = 0
+		item.UserID = user.ID
+		err = validateItem(&item)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		valid = append(valid, item)
+		indexes = append(indexes, i)
+	}
+	err = h.itemRepo.CreateAll(r.Context(), valid)
+	if err != nil {
+		logError(r, err)
+		rd.renderBatchError(w, r, err)
+		return
+	}
+	for j, i := range indexes {
+		results[i] = batchResult{Status: http.StatusCreated, ID: valid[j].ID, Item: &valid[j]}
+	}
+	rd.renderBatch(w, r, results)
+}
+
+// BatchUpdate changes the name, price and tags of items, like Update.
+// The items the current user may act on and whose changes
+// are valid are all updated in a single transaction
+func (h *ItemHandler) BatchUpdate(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	h.limitBatch(w, r)
+	changes, err := rd.parseBatch(r)
+	err = h.checkBatchSize(len(changes), err)
+	if err != nil {
+		rd.renderBatchError(w, r, err)
+		return
+	}
+
+	results := make([]batchResult, len(changes))
+	var valid []app.Item
+	var indexes []int
+	seen := make(map[int]bool)
+	for i, change := range changes {
+		results[i].ID = change.ID
+		if seen[change.ID] {
+			results[i].Err = errDuplicateInBatch
+			continue
+		}
+		seen[change.ID] = true
+		item, err := h.itemForUser(r, change.ID)
+		if err == nil {
+			err = validateItem(&change)
+		}
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		item.Name = change.Name
+		item.Price = change.Price
+		if change.Tags != nil {
+			item.Tags = change.Tags
+		}
+		valid = append(valid, *item)
+		indexes = append(indexes, i)
+	}
+	err = h.itemRepo.UpdateAll(r.Context(), valid)
+	if err != nil {
+		logError(r, err)
+		rd.renderBatchError(w, r, err)
+		return
+	}
+	for j, i := range indexes {
+		results[i] = batchResult{Status: http.StatusOK, ID: valid[j].ID, Item: &valid[j]}
+	}
+	rd.renderBatch(w, r, results)
+}
+
+// BatchDelete removes items. The items the current user
+// may act on are all deleted in a single transaction
+func (h *ItemHandler) BatchDelete(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	h.limitBatch(w, r)
+	ids, err := rd.parseBatchIDs(r)
+	err = h.checkBatchSize(len(ids), err)
+	if err != nil {
+		rd.renderBatchError(w, r, err)
+		return
+	}
+
+	results := make([]batchResult, len(ids))
+	var deleted []*app.Item
+	var valid, indexes []int
+	seen := make(map[int]bool)
+	for i, id := range ids {
+		results[i].ID = id
+		if seen[id] {
+			results[i].Err = errDuplicateInBatch
+			continue
+		}
+		seen[id] = true
+		item, err := h.itemForUser(r, id)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		deleted = append(deleted, item)
+		valid = append(valid, id)
+		indexes = append(indexes, i)
+	}
+	err = h.itemRepo.DeleteAll(r.Context(), valid)
+	if err != nil {
+		logError(r, err)
+		rd.renderBatchError(w, r, err)
+		return
+	}
+	for j, i := range indexes {
+		h.deleteImage(r, deleted[j].Image)
+		results[i] = batchResult{Status: http.StatusNoContent, ID: deleted[j].ID}
+	}
+	rd.renderBatch(w, r, results)
+}
+
+// maxBatchSize returns how many items a batch can act on
+func maxBatchSize(cfg Config) int {
+	if cfg.MaxBatchSize > 0 {
+		return cfg.MaxBatchSize
+	}
+	return defaultMaxBatchSize
+}
+
+// limitBatch bounds the body of a batch request by the room
+// maxBatchSize items take, so that larger batches are refused
+// before they are read whole
+func (h *ItemHandler) limitBatch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(h.maxBatchSize)*maxBatchItemSize)
+}
+
+// checkBatchSize checks that a batch of n items, parsed with err,
+// is not larger than allowed
+func (h *ItemHandler) checkBatchSize(n int, err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || err == nil && n > h.maxBatchSize {
+		return validationError{
+			message: "Batches can have at most %d items",
+			args:    []interface{}{h.maxBatchSize},
+		}
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// csrfField is the form field carrying the CSRF token of a session
+const csrfField = "csrf_token"
+
+// csrfToken returns the token forms of a session must send back,
+// or an empty string without session. It is derived from the session
+// so that pages of other sites, which cannot read it, cannot forge it
+func csrfToken(r *http.Request) string {
+	cookie, err := r.Cookie("session")
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	return hashSecret("csrf:" + cookie.Value)
+}
+
+// checkCSRF refuses forms changing state
+// which do not carry the CSRF token of the session
+func checkCSRF(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := csrfToken(r)
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(r.PostFormValue(csrfField))) != 1 {
+			renderHTMLError(w, r, http.StatusForbidden, "This form has expired. Reload the page and try again.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -3,13 +3,23 @@ package http
 import (
 	"fmt"
 	"strings"
+
+	"golang.org/x/text/message"
 )
 
 type validationError struct {
-	fields  []string
+	fields []string
+	// message is in English, it is the key of its translations.
+	// It is formatted with args
 	message string
+	args    []interface{}
 }
 
 func (e validationError) Error() string {
-	return fmt.Sprintf("http validation error: Fields[%s] are not valid. %s", strings.Join(e.fields, ", "), e.message)
+	return fmt.Sprintf("http validation error: Fields[%s] are not valid. %s", strings.Join(e.fields, ", "), fmt.Sprintf(e.message, e.args...))
+}
+
+// localize formats the message in the language of a printer
+func (e validationError) localize(p *message.Printer) string {
+	return p.Sprintf(e.message, e.args...)
 }
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// flashCookie carries a message to the next page a user sees,
+// usually across the redirect that follows a form
+const flashCookie = "flash"
+
+// Kinds of flash messages
+const (
+	flashNotice = "notice"
+	flashError  = "error"
+)
+
+// flash is a one-time message shown at the top of a page
+type flash struct {
+	Kind    string
+	Message string
+}
+
+// setFlash shows a message on the next page rendered for the user.
+// The message is in English, it is translated when shown
+func setFlash(w http.ResponseWriter, kind, message string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + message)),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// popFlash returns the flash message of a request, if any,
+// and removes it so that it is shown only once
+func popFlash(w http.ResponseWriter, r *http.Request) *flash {
+	cookie, err := r.Cookie(flashCookie)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   flashCookie,
+		Path:   "/",
+		MaxAge: -1,
+	})
+	b, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	kind, message, ok := strings.Cut(string(b), ":")
+	if !ok || (kind != flashNotice && kind != flashError) {
+		return nil
+	}
+	return &flash{Kind: kind, Message: message}
+}
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"net/url"
+
+	"golang.org/x/text/message"
+)
+
+// form is what a user typed in an HTML form and what is wrong with it,
+// to show the form again with the errors next to the inputs
+type form struct {
+	Values url.Values
+	// Errors are messages by field name,
+	// in the language of the user
+	Errors map[string]string
+	// Message is an error about the whole form
+	Message string
+}
+
+func newForm(values url.Values) *form {
+	return &form{
+		Values: values,
+		Errors: make(map[string]string),
+	}
+}
+
+// Value returns what the user typed in a field
+func (f *form) Value(field string) string {
+	return f.Values.Get(field)
+}
+
+// invalid puts the message of a validation error next to its fields
+func (f *form) invalid(p *message.Printer, err validationError) *form {
+	message := err.localize(p)
+	if len(err.fields) == 0 {
+		f.Message = message
+	}
+	for _, field := range err.fields {
+		f.Errors[field] = message
+	}
+	return f
+}
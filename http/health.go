@@ -0,0 +1,102 @@
+package http
+
+import (
+	gocontext "context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	app "useritem"
+	"useritem/context"
+)
+
+// readyTimeout bounds how long readiness checks may take altogether
+const readyTimeout = 2 * time.Second
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// HealthHandler handles the probes of an orchestrator.
+// Dependencies contribute readiness checks by registering a checker
+type HealthHandler struct {
+	mu       sync.RWMutex
+	checkers map[string]app.HealthChecker
+	build    BuildInfo
+}
+
+// NewHealthHandler returns a health handler reporting build info
+func NewHealthHandler(build BuildInfo) *HealthHandler {
+	return &HealthHandler{
+		checkers: make(map[string]app.HealthChecker),
+		build:    build,
+	}
+}
+
+// Register adds a readiness check under a name,
+// replacing any check registered under the same name
+func (h *HealthHandler) Register(name string, checker app.HealthChecker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkers[name] = checker
+}
+
+// Live tells the process is up. It checks no dependency,
+// so that a broken database does not get the process restarted
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	renderJSON(w, map[string]string{"status": "ok"}, http.StatusOK)
+}
+
+// Ready runs every registered check at once
+// and tells whether the service can take traffic.
+// Checks only report ok or unavailable, why they failed is logged
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := gocontext.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checkers))
+	for name := range h.checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, checker app.HealthChecker) {
+			defer wg.Done()
+			errs[i] = checker.Check(ctx)
+		}(i, h.checkers[name])
+	}
+	h.mu.RUnlock()
+	wg.Wait()
+
+	status := http.StatusOK
+	checks := make(map[string]string, len(names))
+	for i, name := range names {
+		checks[name] = "ok"
+		if errs[i] != nil {
+			context.Logger(r.Context()).Error("readiness check failed", "check", name, "err", errs[i])
+			checks[name] = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	data := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{"ok", checks}
+	if status != http.StatusOK {
+		data.Status = "unavailable"
+	}
+	renderJSON(w, data, status)
+}
+
+// Version reports which build is running
+func (h *HealthHandler) Version(w http.ResponseWriter, r *http.Request) {
+	renderJSON(w, h.build, http.StatusOK)
+}
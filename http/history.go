@@ -0,0 +1,60 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	app "useritem"
+
+	"github.com/gorilla/mux"
+)
+
+// itemHistory is the history of an item
+type itemHistory struct {
+	ItemID int
+	// Changes are newest first
+	Changes []app.ItemChange
+}
+
+// History shows the changes of an item, newest first.
+// Deleted items keep their history, which is shown
+// to those who could act on the item
+func (h *ItemHandler) History(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rd.renderShowError(w, r, app.ErrNotFound)
+		return
+	}
+	changes, err := h.itemRepo.History(r.Context(), id)
+	if err != nil {
+		logError(r, err)
+		rd.renderShowError(w, r, err)
+		return
+	}
+	if len(changes) == 0 {
+		// Items from before histories were kept have none
+		_, err = h.itemForUser(r, id)
+	} else {
+		err = canSeeHistory(r, changes[0])
+	}
+	if err != nil {
+		rd.renderShowError(w, r, err)
+		return
+	}
+	rd.renderHistory(w, r, itemHistory{ItemID: id, Changes: changes})
+}
+
+// canSeeHistory checks that the current user may see the history
+// of an item, given a change of it: users see the history of their
+// own items, and of anybody's if they are allowed to manage items
+func canSeeHistory(r *http.Request, change app.ItemChange) error {
+	item := change.After
+	if item == nil {
+		item = change.Before
+	}
+	user := currentUser(r)
+	if item.UserID != user.ID && !user.Can(app.PermManageItems) {
+		return errForbidden
+	}
+	return nil
+}
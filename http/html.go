@@ -1,12 +1,21 @@
 package http
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 	app "useritem"
 	"useritem/context"
+	"useritem/i18n"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
 )
 
 type htmlAuthMw struct {
@@ -32,9 +41,9 @@ func (a *htmlAuthMw) SetUser(next http.Handler) http.HandlerFunc {
 			return
 		}
 
-		user, err := a.userRepo.ByToken(session)
-		if err != nil {
-			// No user found, move on
+		user, err := a.userRepo.ByToken(r.Context(), session)
+		if err != nil || user.Disabled {
+			// No active user found, move on
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -47,34 +56,81 @@ func (a *htmlAuthMw) SetUser(next http.Handler) http.HandlerFunc {
 // if no user found, redirect to sign in
 func (a *htmlAuthMw) RequireUser(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tmp := context.User(r.Context())
-		if tmp == nil {
+		if optionalUser(r) == nil {
 			// No user found
 			http.Redirect(w, r, "/signin", http.StatusFound)
 			return
 		}
+		setPrivate(w)
 		next.ServeHTTP(w, r)
 	}
 }
 
-func htmlUserHandler(userRepo app.UserRepo) *UserHandler {
-	uh := UserHandler{
-		userRepo: userRepo,
-		renderSignin: func(w http.ResponseWriter) {
-			html := `
-			<!DOCTYPE html>
-			<html lang="en">
-				<form action="/signin" method="POST">
-					<label for="email">Email Address</label>
-					<input type="email" id="email" name="email" placeholder="you@example.com">
+// RequireScope requires the access token of a request to have a scope.
+// HTML requests are authenticated by sessions, which hold every scope
+func (a *htmlAuthMw) RequireScope(scope app.Scope) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !hasScope(r, scope) {
+				renderHTMLError(w, r, http.StatusForbidden, "You are not allowed to do this.")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
 
-					<label for="password">Password</label>
-					<input type="password" id="password" name="password" placeholder="something-secret">
+// RequireRole requires the user of a request to have a role
+func (a *htmlAuthMw) RequireRole(role app.Role) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !hasRole(r, role) {
+				renderHTMLError(w, r, http.StatusForbidden, "You are not allowed to do this.")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
 
-					<button type="submit">Sign in</button>
-				</form>
-			</html>`
-			fmt.Fprint(w, html)
+// RequirePermission requires the user of a request to have a permission
+func (a *htmlAuthMw) RequirePermission(perm app.Permission) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !hasPermission(r, perm) {
+				renderHTMLError(w, r, http.StatusForbidden, "You are not allowed to do this.")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// htmlRenderer returns the renderer of web pages, whose templates
+// are loaded from Config.TemplatesDir if set
+// return an error if a template is broken
+func htmlRenderer(cfg Config) (*Renderer, error) {
+	tpl, err := loadTemplates(cfg.TemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Renderer{
+		auth:          &htmlAuthMw{userRepo: cfg.UserRepo},
+		users:         htmlUserRenderer(tpl, strings.HasPrefix(cfg.BaseURL, "https://")),
+		items:         htmlItemRenderer(tpl),
+		accessTokens:  htmlAccessTokenRenderer(tpl),
+		admin:         htmlAdminRenderer(tpl),
+		internalError: renderHTMLInternalError,
+		web:           true,
+	}, nil
+}
+
+// htmlUserRenderer renders the pages of users.
+// Cookies are only sent over HTTPS when secure is set
+func htmlUserRenderer(tpl *templates, secure bool) *userRenderer {
+	uh := userRenderer{
+		renderSignin: func(w http.ResponseWriter, r *http.Request) {
+			tpl.render(w, r, http.StatusOK, "signin", newForm(nil))
 		},
 		parseEmailAndPassword: func(r *http.Request) (email, password string) {
 			email = r.PostFormValue("email")
@@ -83,8 +139,12 @@ func htmlUserHandler(userRepo app.UserRepo) *UserHandler {
 		},
 		renderProcessSigninSuccess: func(w http.ResponseWriter, r *http.Request, token int) {
 			cookie := http.Cookie{
-				Name:  "session",
-				Value: strconv.Itoa(token),
+				Name:     "session",
+				Value:    strconv.Itoa(token),
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   secure,
+				SameSite: http.SameSiteLaxMode,
 			}
 			http.SetCookie(w, &cookie)
 			http.Redirect(w, r, "/items", http.StatusFound)
@@ -92,74 +152,697 @@ func htmlUserHandler(userRepo app.UserRepo) *UserHandler {
 		renderProcessSigninError: func(w http.ResponseWriter, r *http.Request, err error) {
 			switch err {
 			case errAuthFailed:
+				f := newForm(r.PostForm)
+				f.Message = localizer(r).Sprintf("Email address or password is not correct.")
+				tpl.render(w, r, http.StatusUnauthorized, "signin", f)
+			case errAccountDisabled:
+				f := newForm(r.PostForm)
+				f.Message = localizer(r).Sprintf("This account is disabled. Contact support.")
+				tpl.render(w, r, http.StatusForbidden, "signin", f)
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
+		},
+		renderForgotPassword: func(w http.ResponseWriter, r *http.Request) {
+			tpl.render(w, r, http.StatusOK, "forgot_password", nil)
+		},
+		parseEmail: func(r *http.Request) string {
+			return r.PostFormValue("email")
+		},
+		renderProcessForgotPasswordSuccess: func(w http.ResponseWriter, r *http.Request) {
+			tpl.render(w, r, http.StatusOK, "forgot_password_sent", nil)
+		},
+		renderProcessForgotPasswordError: func(w http.ResponseWriter, r *http.Request, err error) {
+			renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+		},
+		renderResetPassword: func(w http.ResponseWriter, r *http.Request, token string) {
+			tpl.render(w, r, http.StatusOK, "reset_password", token)
+		},
+		parseTokenAndPassword: func(r *http.Request) (token, password string) {
+			token = r.PostFormValue("token")
+			password = r.PostFormValue("password")
+			return token, password
+		},
+		renderProcessResetPasswordSuccess: func(w http.ResponseWriter, r *http.Request) {
+			setFlash(w, flashNotice, "Your password is changed. Sign in with it.")
+			http.Redirect(w, r, "/signin", http.StatusFound)
+		},
+		renderProcessResetPasswordError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderHTMLError(w, r, http.StatusBadRequest, v.message, v.args...)
+			default:
+				switch err {
+				case errInvalidToken:
+					renderHTMLError(w, r, http.StatusBadRequest, "This link is invalid or has expired. Ask for a new one.")
+				default:
+					renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+				}
+			}
+		},
+		renderSignup: func(w http.ResponseWriter, r *http.Request) {
+			tpl.render(w, r, http.StatusOK, "signup", newForm(nil))
+		},
+		parseSignup: func(r *http.Request) (name, email, password string) {
+			name = r.PostFormValue("name")
+			email = r.PostFormValue("email")
+			password = r.PostFormValue("password")
+			return name, email, password
+		},
+		renderProcessSignupError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				tpl.render(w, r, http.StatusBadRequest, "signup", newForm(r.PostForm).invalid(localizer(r), v))
+			default:
+				switch err {
+				case errEmailTaken:
+					f := newForm(r.PostForm)
+					f.Errors["email"] = localizer(r).Sprintf("This email address is already taken.")
+					tpl.render(w, r, http.StatusConflict, "signup", f)
+				default:
+					renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+				}
+			}
+		},
+		renderAccount: func(w http.ResponseWriter, r *http.Request, user *app.User) {
+			tpl.render(w, r, http.StatusOK, "account", user)
+		},
+		renderProcessChangeEmailSuccess: func(w http.ResponseWriter, r *http.Request) {
+			setFlash(w, flashNotice, "Check your inbox for a link to verify your new email address.")
+			http.Redirect(w, r, "/account", http.StatusFound)
+		},
+		renderProcessChangeEmailError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderHTMLError(w, r, http.StatusBadRequest, v.message, v.args...)
+			default:
+				switch err {
+				case errAuthFailed:
+					renderHTMLError(w, r, http.StatusBadRequest, "Your current password is not correct.")
+				case errEmailTaken:
+					renderHTMLError(w, r, http.StatusConflict, "This email address is already taken.")
+				default:
+					renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+				}
+			}
+		},
+		renderVerifyEmailSuccess: func(w http.ResponseWriter, r *http.Request) {
+			tpl.render(w, r, http.StatusOK, "email_verified", nil)
+		},
+		renderVerifyEmailError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case errInvalidToken:
+				renderHTMLError(w, r, http.StatusBadRequest, "This link is invalid or has expired. Ask for a new one from your account page.")
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
+		},
+		renderResendVerificationSuccess: func(w http.ResponseWriter, r *http.Request) {
+			setFlash(w, flashNotice, "A new verification mail is on its way.")
+			http.Redirect(w, r, "/account", http.StatusFound)
+		},
+		renderResendVerificationError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case errAlreadyVerified:
+				http.Redirect(w, r, "/account", http.StatusFound)
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
+		},
+		renderTwoFactorChallenge: func(w http.ResponseWriter, r *http.Request, challenge string) {
+			cookie := http.Cookie{
+				Name:     "two_factor",
+				Value:    challenge,
+				Path:     "/signin/2fa",
+				MaxAge:   int(twoFactorTTL / time.Second),
+				HttpOnly: true,
+				Secure:   secure,
+			}
+			http.SetCookie(w, &cookie)
+			http.Redirect(w, r, "/signin/2fa", http.StatusFound)
+		},
+		renderTwoFactor: func(w http.ResponseWriter, r *http.Request) {
+			tpl.render(w, r, http.StatusOK, "two_factor", nil)
+		},
+		parseChallengeAndCode: func(r *http.Request) (challenge, code string) {
+			cookie, err := r.Cookie("two_factor")
+			if err == nil {
+				challenge = cookie.Value
+			}
+			code = r.PostFormValue("code")
+			return challenge, code
+		},
+		renderProcessTwoFactorError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case errInvalidToken:
+				setFlash(w, flashError, "Your sign in has expired. Sign in again.")
+				http.Redirect(w, r, "/signin", http.StatusFound)
+			case errInvalidCode:
+				// The challenge is used up, start over
+				setFlash(w, flashError, "The code is not valid. Sign in again.")
 				http.Redirect(w, r, "/signin", http.StatusFound)
+			case errAccountDisabled:
+				renderHTMLError(w, r, http.StatusForbidden, "This account is disabled. Contact support.")
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
+		},
+		renderTwoFactorSetup: func(w http.ResponseWriter, r *http.Request, setup twoFactorSetup) {
+			tpl.render(w, r, http.StatusOK, "two_factor_setup", setup)
+		},
+		renderTwoFactorSetupError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case errTwoFactorEnabled:
+				http.Redirect(w, r, "/account", http.StatusFound)
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
+		},
+		parseCode: func(r *http.Request) string {
+			return r.PostFormValue("code")
+		},
+		renderProcessTwoFactorSetupSuccess: func(w http.ResponseWriter, r *http.Request, recoveryCodes []string) {
+			tpl.render(w, r, http.StatusOK, "recovery_codes", recoveryCodes)
+		},
+		renderProcessTwoFactorSetupError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case errInvalidCode:
+				renderHTMLError(w, r, http.StatusBadRequest, "The code is not valid. Go back and try again.")
+			case errTwoFactorEnabled:
+				http.Redirect(w, r, "/account", http.StatusFound)
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
+		},
+		parseRole: func(r *http.Request) app.Role {
+			return app.Role(r.PostFormValue("role"))
+		},
+		renderUpdateRoleSuccess: func(w http.ResponseWriter, r *http.Request, user *app.User) {
+			http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", user.ID), http.StatusFound)
+		},
+		renderUpdateRoleError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderHTMLError(w, r, http.StatusBadRequest, v.message, v.args...)
+			default:
+				switch err {
+				case app.ErrNotFound:
+					http.NotFound(w, r)
+				default:
+					renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+				}
+			}
+		},
+		parseLanguage: func(r *http.Request) string {
+			return r.PostFormValue("language")
+		},
+		renderUpdateLanguageSuccess: func(w http.ResponseWriter, r *http.Request, lang language.Tag) {
+			cookie := http.Cookie{
+				Name:     languageCookie,
+				Value:    lang.String(),
+				Path:     "/",
+				MaxAge:   int(365 * 24 * time.Hour / time.Second),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			}
+			http.SetCookie(w, &cookie)
+			http.Redirect(w, r, backURL(r), http.StatusFound)
+		},
+		renderUpdateLanguageError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderHTMLError(w, r, http.StatusBadRequest, v.message, v.args...)
 			default:
-				http.Error(w, "Something went wrong. Try again later.", http.StatusInternalServerError)
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
 			}
 		},
 	}
 	return &uh
 }
 
-func htmlItemHandler(itemRepo app.ItemRepo) *ItemHandler {
-	ih := ItemHandler{
-		itemRepo: itemRepo,
-		renderNew: func(w http.ResponseWriter) {
-			html := `
-			<!DOCTYPE html>
-			<html lang="en">
-				<form action="/items" method="POST">
-					<label for="name">Name</label>
-					<input type="text" id="name" name="name" placeholder="Stop Item">
+// itemForm is the data of the pages to create and edit an item
+type itemForm struct {
+	// ID is the id of the item to edit, 0 for a new item
+	ID   int
+	Form *form
+	// TagSuggestions are the tags of the owner of the item
+	TagSuggestions []string
+	// Image is the current image of the item to edit, if any
+	Image *app.Image
+}
+
+// itemList is the data of the page listing items
+type itemList struct {
+	Items []app.Item
+	// Tag is the tag items are filtered by, if any
+	Tag string
+	// UserID is the id of the user items are listed for
+	UserID int
+}
 
-					<label for="price">Price</label>
-					<input type="number" id="price" name="price" placeholder="18">
+// importPage is the data of the page importing items
+type importPage struct {
+	Form *form
+	// Errors are what is wrong with the lines of the file sent,
+	// in the language of the user
+	Errors []importLineError
+	// Checked is how many items a dry run found valid
+	Checked int
+}
+
+type importLineError struct {
+	Line    int
+	Message string
+}
 
-					<button type="submit">Create it!</button>
-				</form>
-			</html>`
-			fmt.Fprint(w, html)
+// historyPage is the data of the page showing the history of an item
+type historyPage struct {
+	ItemID  int
+	Entries []historyEntry
+	// UserID is the id of the current user,
+	// to tell the changes they made
+	UserID int
+}
+
+// historyEntry is a change of an item along what changed
+type historyEntry struct {
+	app.ItemChange
+	Fields []fieldChange
+}
+
+// fieldChange is a field of an item that changed, with its values
+// before and after, formatted in the language of the user.
+// Values are empty when there is none, like before a creation
+type fieldChange struct {
+	// Field is the English name of the field, to translate
+	Field  string
+	Before string
+	After  string
+}
+
+// itemDiff tells which fields differ between two versions of an item,
+// either of which may be nil. Images are told apart by their key
+// and shown by their type
+func itemDiff(p *message.Printer, before, after *app.Item) []fieldChange {
+	type values struct {
+		name, price, tags string
+		image, imageKey   string
+	}
+	read := func(item *app.Item) values {
+		var v values
+		if item == nil {
+			return v
+		}
+		v.name = item.Name
+		v.price = p.Sprintf("%d VND", item.Price)
+		v.tags = strings.Join(item.Tags, ", ")
+		if item.Image != nil {
+			v.image, v.imageKey = item.Image.ContentType, item.Image.Key
+		}
+		return v
+	}
+	b, a := read(before), read(after)
+	var fields []fieldChange
+	if b.name != a.name {
+		fields = append(fields, fieldChange{Field: "Name", Before: b.name, After: a.name})
+	}
+	if b.price != a.price {
+		fields = append(fields, fieldChange{Field: "Price", Before: b.price, After: a.price})
+	}
+	if b.tags != a.tags {
+		fields = append(fields, fieldChange{Field: "Tags", Before: b.tags, After: a.tags})
+	}
+	if b.imageKey != a.imageKey {
+		fields = append(fields, fieldChange{Field: "Image", Before: b.image, After: a.image})
+	}
+	return fields
+}
+
+func htmlItemRenderer(tpl *templates) *itemRenderer {
+	ih := itemRenderer{
+		renderNew: func(w http.ResponseWriter, r *http.Request, tagSuggestions []string) {
+			tpl.render(w, r, http.StatusOK, "item_new", itemForm{
+				Form:           newForm(nil),
+				TagSuggestions: tagSuggestions,
+			})
 		},
 		parseItem: func(r *http.Request) (*app.Item, error) {
-			// Parse form values
-			user := context.User(r.Context())
+			// Parse form values, and the image along them if any
+			err := parseMultipartForm(r)
+			if err != nil {
+				return nil, err
+			}
 			item := app.Item{
-				UserID: user.ID,
-				Name:   r.PostFormValue("name"),
+				Name: r.PostFormValue("name"),
+				Tags: normalizeTags(strings.Split(r.PostFormValue("tags"), ",")),
 			}
-			var err error
 			item.Price, err = strconv.Atoi(r.PostFormValue("price"))
-			return &item, err
+			if err != nil {
+				return nil, validationError{
+					fields:  []string{"price"},
+					message: "Price must be a number",
+				}
+			}
+			return &item, nil
 		},
 		renderCreateSuccess: func(w http.ResponseWriter, r *http.Request, item *app.Item) {
+			setFlash(w, flashNotice, "Item created.")
 			http.Redirect(w, r, "/items", http.StatusFound)
 		},
 		renderCreateError: func(w http.ResponseWriter, r *http.Request, err error) {
-			http.Error(w, "Something went wrong. Try again later.", http.StatusInternalServerError)
+			if v, ok := err.(validationError); ok {
+				tpl.render(w, r, http.StatusBadRequest, "item_new", itemForm{
+					Form: newForm(r.PostForm).invalid(localizer(r), v),
+				})
+				return
+			}
+			switch err {
+			case errEmailNotVerified:
+				tpl.render(w, r, http.StatusForbidden, "email_not_verified", nil)
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
 		},
 		renderIndexSuccess: func(w http.ResponseWriter, r *http.Request, items []app.Item) error {
-			tplStr := `
-			<!DOCTYPE html>
-			<html lang="en">
-				<h1>Items</h1>
-
-				<ul>
-				{{range .}}
-				<li> {{.Name}}: <b>{{.Price}}VNĐ</b></li>
-				{{end}}
-				</ul>
-
-				<p>
-				<a href="/items/new">Create a new item</a>
-				</p>
-			</html>`
-			tpl := template.Must(template.New("").Parse(tplStr))
-			err := tpl.Execute(w, items)
-			return err
+			list := itemList{
+				Items:  items,
+				Tag:    normalizeTag(r.URL.Query().Get("tag")),
+				UserID: currentUser(r).ID,
+			}
+			if id, err := strconv.Atoi(r.URL.Query().Get("user")); err == nil {
+				list.UserID = id
+			}
+			tpl.render(w, r, http.StatusOK, "items", list)
+			return nil
 		},
 		renderIndexError: func(w http.ResponseWriter, r *http.Request, err error) {
-			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+			if v, ok := err.(validationError); ok {
+				renderHTMLError(w, r, http.StatusBadRequest, v.message, v.args...)
+				return
+			}
+			switch err {
+			case app.ErrNotFound:
+				http.NotFound(w, r)
+			case errForbidden:
+				renderHTMLError(w, r, http.StatusForbidden, "You are not allowed to see these items.")
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong.")
+			}
+		},
+		renderSearch: func(w http.ResponseWriter, r *http.Request, search itemSearch) {
+			tpl.render(w, r, http.StatusOK, "items_search", struct {
+				itemSearch
+				PrevPage int
+				NextPage int
+			}{
+				itemSearch: search,
+				PrevPage:   search.Page - 1,
+				NextPage:   search.Page + 1,
+			})
+		},
+		renderEdit: func(w http.ResponseWriter, r *http.Request, item *app.Item, tagSuggestions []string) {
+			tpl.render(w, r, http.StatusOK, "item_edit", itemForm{
+				ID: item.ID,
+				Form: newForm(url.Values{
+					"name":  {item.Name},
+					"price": {strconv.Itoa(item.Price)},
+					"tags":  {strings.Join(item.Tags, ", ")},
+				}),
+				TagSuggestions: tagSuggestions,
+				Image:          item.Image,
+			})
+		},
+		renderShowError: renderHTMLItemError,
+		renderUpdateError: func(w http.ResponseWriter, r *http.Request, err error) {
+			if v, ok := err.(validationError); ok {
+				// Show the form again with what was typed
+				id, _ := strconv.Atoi(mux.Vars(r)["id"])
+				tpl.render(w, r, http.StatusBadRequest, "item_edit", itemForm{
+					ID:   id,
+					Form: newForm(r.PostForm).invalid(localizer(r), v),
+				})
+				return
+			}
+			renderHTMLItemError(w, r, err)
+		},
+		renderUpdateSuccess: func(w http.ResponseWriter, r *http.Request, item *app.Item) {
+			setFlash(w, flashNotice, "Item saved.")
+			http.Redirect(w, r, htmlItemsURL(r, item), http.StatusFound)
+		},
+		renderDeleteSuccess: func(w http.ResponseWriter, r *http.Request, item *app.Item) {
+			setFlash(w, flashNotice, "Item deleted.")
+			http.Redirect(w, r, htmlItemsURL(r, item), http.StatusFound)
+		},
+		renderDeleteError: renderHTMLItemError,
+		renderHistory: func(w http.ResponseWriter, r *http.Request, history itemHistory) {
+			page := historyPage{
+				ItemID: history.ItemID,
+				UserID: currentUser(r).ID,
+			}
+			p := localizer(r)
+			for _, change := range history.Changes {
+				page.Entries = append(page.Entries, historyEntry{
+					ItemChange: change,
+					Fields:     itemDiff(p, change.Before, change.After),
+				})
+			}
+			tpl.render(w, r, http.StatusOK, "item_history", page)
+		},
+		renderImportForm: func(w http.ResponseWriter, r *http.Request) {
+			tpl.render(w, r, http.StatusOK, "items_import", importPage{
+				Form: newForm(url.Values{"format": {formatCSV}}),
+			})
+		},
+		parseImport: func(r *http.Request) (*importFile, error) {
+			err := r.ParseMultipartForm(maxImportSize + maxFormSize)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, errImportTooLarge
+			}
+			if err != nil {
+				return nil, validationError{
+					fields:  []string{"file"},
+					message: "The file cannot be read",
+				}
+			}
+			f, _, err := r.FormFile("file")
+			if err != nil {
+				return nil, validationError{
+					fields:  []string{"file"},
+					message: "A file is required",
+				}
+			}
+			return &importFile{
+				Format: r.PostFormValue("format"),
+				Body:   f,
+				DryRun: r.PostFormValue("dry_run") != "",
+			}, nil
+		},
+		renderImport: func(w http.ResponseWriter, r *http.Request, res itemImport) {
+			if len(res.Errors) == 0 && !res.DryRun {
+				setFlash(w, flashNotice, "Items imported.")
+				http.Redirect(w, r, "/items", http.StatusFound)
+				return
+			}
+			page := importPage{
+				Form:    newForm(r.PostForm),
+				Checked: len(res.Items),
+			}
+			status := http.StatusOK
+			if len(res.Errors) > 0 {
+				status = http.StatusBadRequest
+				p := localizer(r)
+				for _, e := range res.Errors {
+					page.Errors = append(page.Errors, importLineError{Line: e.Line, Message: e.Err.localize(p)})
+				}
+			}
+			tpl.render(w, r, status, "items_import", page)
+		},
+		renderImportError: func(w http.ResponseWriter, r *http.Request, err error) {
+			if v, ok := err.(validationError); ok {
+				tpl.render(w, r, http.StatusBadRequest, "items_import", importPage{
+					Form: newForm(r.PostForm).invalid(localizer(r), v),
+				})
+				return
+			}
+			switch err {
+			case errEmailNotVerified:
+				tpl.render(w, r, http.StatusForbidden, "email_not_verified", nil)
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
 		},
 	}
 	return &ih
 }
+
+// backURL returns the page a form was sent from,
+// or the items if it was on another site
+func backURL(r *http.Request) string {
+	ref, err := url.Parse(r.Referer())
+	if err != nil || ref.Host != r.Host || !strings.HasPrefix(ref.Path, "/") {
+		return "/items"
+	}
+	return ref.RequestURI()
+}
+
+// htmlItemsURL returns the URL of the list an item belongs to
+func htmlItemsURL(r *http.Request, item *app.Item) string {
+	user := optionalUser(r)
+	if user != nil && user.ID == item.UserID {
+		return "/items"
+	}
+	return fmt.Sprintf("/items?user=%d", item.UserID)
+}
+
+// renderHTMLError replies with an error message
+// in the language of the user
+func renderHTMLError(w http.ResponseWriter, r *http.Request, code int, message string, args ...interface{}) {
+	http.Error(w, localizer(r).Sprintf(message, args...), code)
+}
+
+// renderHTMLInternalError renders a page that gives nothing away
+// but the request id, so support can find the logs
+func renderHTMLInternalError(w http.ResponseWriter, r *http.Request) {
+	lang := requestLanguage(r)
+	p := i18n.NewPrinter(lang)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	html := `
+	<!DOCTYPE html>
+	<html lang="%s">
+		<h1>%s</h1>
+		<p>
+		%s <code>%s</code>
+		</p>
+		<p>
+		<a href="/items">%s</a>
+		</p>
+	</html>`
+	fmt.Fprintf(w, html, lang,
+		template.HTMLEscapeString(p.Sprintf("Something went wrong")),
+		template.HTMLEscapeString(p.Sprintf("Try again later. If the problem persists, contact support and give them this reference:")),
+		template.HTMLEscapeString(context.RequestID(r.Context())),
+		template.HTMLEscapeString(p.Sprintf("Back to your items")))
+}
+
+func renderHTMLItemError(w http.ResponseWriter, r *http.Request, err error) {
+	switch v := err.(type) {
+	case validationError:
+		renderHTMLError(w, r, http.StatusBadRequest, v.message, v.args...)
+	default:
+		switch err {
+		case app.ErrNotFound:
+			http.NotFound(w, r)
+		case errForbidden:
+			renderHTMLError(w, r, http.StatusForbidden, "You are not allowed to act on this item.")
+		default:
+			renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+		}
+	}
+}
+
+func htmlAccessTokenRenderer(tpl *templates) *accessTokenRenderer {
+	th := accessTokenRenderer{
+		renderIndexSuccess: func(w http.ResponseWriter, r *http.Request, tokens []app.AccessToken) {
+			tpl.render(w, r, http.StatusOK, "tokens", struct {
+				Tokens []app.AccessToken
+				Scopes []app.Scope
+			}{
+				Tokens: tokens,
+				Scopes: app.GrantableScopes,
+			})
+		},
+		renderIndexError: func(w http.ResponseWriter, r *http.Request, err error) {
+			renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong.")
+		},
+		parseAccessToken: func(r *http.Request) (*app.AccessToken, error) {
+			err := r.ParseForm()
+			if err != nil {
+				return nil, err
+			}
+			token := app.AccessToken{
+				Name: r.PostFormValue("name"),
+			}
+			for _, scope := range r.PostForm["scopes"] {
+				token.Scopes = append(token.Scopes, app.Scope(scope))
+			}
+			if days := r.PostFormValue("expires_in_days"); days != "" {
+				n, err := strconv.Atoi(days)
+				if err != nil {
+					return nil, validationError{
+						fields:  []string{"expires_in_days"},
+						message: "Expiry must be a number of days",
+					}
+				}
+				token.ExpiresAt = time.Now().AddDate(0, 0, n)
+			}
+			return &token, nil
+		},
+		renderCreateSuccess: func(w http.ResponseWriter, r *http.Request, token *app.AccessToken, secret string) {
+			tpl.render(w, r, http.StatusOK, "token_created", secret)
+		},
+		renderCreateError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderHTMLError(w, r, http.StatusBadRequest, v.message, v.args...)
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
+		},
+		renderDeleteSuccess: func(w http.ResponseWriter, r *http.Request) {
+			setFlash(w, flashNotice, "Access token revoked.")
+			http.Redirect(w, r, "/tokens", http.StatusFound)
+		},
+		renderDeleteError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case app.ErrNotFound:
+				http.NotFound(w, r)
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
+		},
+	}
+	return &th
+}
+
+func htmlAdminRenderer(tpl *templates) *adminRenderer {
+	ah := adminRenderer{
+		renderIndex: func(w http.ResponseWriter, r *http.Request, list adminUserList) {
+			tpl.render(w, r, http.StatusOK, "admin_users", struct {
+				adminUserList
+				PrevPage int
+				NextPage int
+			}{
+				adminUserList: list,
+				PrevPage:      list.Page - 1,
+				NextPage:      list.Page + 1,
+			})
+		},
+		renderUser: func(w http.ResponseWriter, r *http.Request, user *app.User, items []app.Item) {
+			tpl.render(w, r, http.StatusOK, "admin_user", struct {
+				User  *app.User
+				Items []app.Item
+				Roles []app.Role
+			}{
+				User:  user,
+				Items: items,
+				Roles: []app.Role{app.RoleUser, app.RoleAdmin},
+			})
+		},
+		renderActionSuccess: func(w http.ResponseWriter, r *http.Request, user *app.User) {
+			http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", user.ID), http.StatusFound)
+		},
+		renderError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case app.ErrNotFound:
+				http.NotFound(w, r)
+			case errForbidden:
+				renderHTMLError(w, r, http.StatusForbidden, "You cannot do this to your own account.")
+			default:
+				renderHTMLError(w, r, http.StatusInternalServerError, "Something went wrong. Try again later.")
+			}
+		},
+	}
+	return &ah
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"net/http"
+	"useritem/i18n"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// languageCookie remembers the language a visitor picked
+const languageCookie = "language"
+
+// requestLanguage returns the language to answer a request in:
+// the preference of the user if signed in, else the language picked
+// by the visitor, else the best one of Accept-Language
+func requestLanguage(r *http.Request) language.Tag {
+	var prefs []string
+	if user := optionalUser(r); user != nil {
+		prefs = append(prefs, user.Language)
+	}
+	if cookie, err := r.Cookie(languageCookie); err == nil {
+		prefs = append(prefs, cookie.Value)
+	}
+	prefs = append(prefs, r.Header.Get("Accept-Language"))
+	return i18n.Match(prefs...)
+}
+
+// localizer returns a printer into the language of a request
+func localizer(r *http.Request) *message.Printer {
+	return i18n.NewPrinter(requestLanguage(r))
+}
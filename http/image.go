@@ -0,0 +1,357 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strconv"
+	app "useritem"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// maxImageSize is the size of the largest image users can upload, in bytes
+	maxImageSize = 5 << 20
+	// maxImagePixels bounds the size of decoded images,
+	// as small files can hold huge pictures
+	maxImagePixels = 40000000
+	// maxFormSize is what multipart forms can hold besides their image, in bytes
+	maxFormSize = 1 << 20
+	// thumbnailSize is the width and height thumbnails fit in, in pixels
+	thumbnailSize = 256
+)
+
+// imageExtensions are the types of images users can upload,
+// with the extension of their blobs
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+var (
+	errImageTooLarge = validationError{
+		fields:  []string{"image"},
+		message: "Images must be at most %d MB",
+		args:    []interface{}{maxImageSize >> 20},
+	}
+	errImageRequired = validationError{
+		fields:  []string{"image"},
+		message: "An image is required",
+	}
+)
+
+// upload is an image sent by an user, before it is stored
+type upload struct {
+	data        []byte
+	contentType string
+	thumbnail   []byte
+}
+
+// Image sends the image of an item
+func (h *ItemHandler) Image(w http.ResponseWriter, r *http.Request) {
+	h.serveImage(w, r, false)
+}
+
+// Thumbnail sends the thumbnail of the image of an item
+func (h *ItemHandler) Thumbnail(w http.ResponseWriter, r *http.Request) {
+	h.serveImage(w, r, true)
+}
+
+// serveImage sends the image of an item or its thumbnail
+// to those allowed to see the item
+func (h *ItemHandler) serveImage(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	rd := rendererOf(r).items
+	item, err := h.itemFromRequest(r)
+	if err != nil {
+		rd.renderShowError(w, r, err)
+		return
+	}
+	if item.Image == nil {
+		rd.renderShowError(w, r, app.ErrNotFound)
+		return
+	}
+	key, contentType, size := item.Image.Key, item.Image.ContentType, item.Image.Size
+	if thumbnail {
+		key, contentType, size = item.Image.ThumbnailKey, "image/jpeg", 0
+	}
+
+	// Keys change along images, so they make fine ETags
+	etag := `"` + key + `"`
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", "private, no-cache")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, err := h.blobStore.Get(r.Context(), key)
+	if err != nil {
+		logError(r, err)
+		header.Del("ETag")
+		header.Del("Cache-Control")
+		rd.renderShowError(w, r, err)
+		return
+	}
+	defer blob.Close()
+	header.Set("Content-Type", contentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	if size > 0 {
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	_, err = io.Copy(w, blob)
+	if err != nil {
+		logError(r, err)
+	}
+}
+
+// UploadImage attaches the image in the "image" field of a multipart form
+// to an item, in place of the one it had
+func (h *ItemHandler) UploadImage(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	item, err := h.itemFromRequest(r)
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	limitImageBody(w, r)
+	err = parseMultipartForm(r)
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	upload, err := parseImage(r)
+	if err == nil && upload == nil {
+		err = errImageRequired
+	}
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+
+	old := item.Image
+	item.Image, err = h.storeImage(r.Context(), upload)
+	if err != nil {
+		logError(r, err)
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	err = h.itemRepo.Update(r.Context(), item)
+	if err != nil {
+		logError(r, err)
+		h.deleteImage(r, item.Image)
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	h.deleteImage(r, old)
+	rd.renderUpdateSuccess(w, r, item)
+}
+
+// DeleteImage removes the image of an item
+func (h *ItemHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	item, err := h.itemFromRequest(r)
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	if item.Image == nil {
+		rd.renderUpdateError(w, r, app.ErrNotFound)
+		return
+	}
+
+	old := item.Image
+	item.Image = nil
+	err = h.itemRepo.Update(r.Context(), item)
+	if err != nil {
+		logError(r, err)
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	h.deleteImage(r, old)
+	rd.renderUpdateSuccess(w, r, item)
+}
+
+// storeImage puts an upload and its thumbnail in the blob store,
+// under new keys so that clients never mix up the versions of an image
+func (h *ItemHandler) storeImage(ctx context.Context, upload *upload) (*app.Image, error) {
+	name, err := newImageName()
+	if err != nil {
+		return nil, err
+	}
+	image := app.Image{
+		Key:          "images/" + name + imageExtensions[upload.contentType],
+		ThumbnailKey: "thumbnails/" + name + ".jpg",
+		ContentType:  upload.contentType,
+		Size:         int64(len(upload.data)),
+	}
+	err = h.blobStore.Put(ctx, image.Key, bytes.NewReader(upload.data), image.Size, image.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	err = h.blobStore.Put(ctx, image.ThumbnailKey, bytes.NewReader(upload.thumbnail), int64(len(upload.thumbnail)), "image/jpeg")
+	if err != nil {
+		h.blobStore.Delete(ctx, image.Key)
+		return nil, err
+	}
+	return &image, nil
+}
+
+// deleteImage removes an image and its thumbnail from the blob store.
+// Failures are only logged: the item no longer points to the image,
+// leftover blobs just waste space
+func (h *ItemHandler) deleteImage(r *http.Request, image *app.Image) {
+	if image == nil {
+		return
+	}
+	for _, key := range []string{image.Key, image.ThumbnailKey} {
+		err := h.blobStore.Delete(r.Context(), key)
+		if err != nil {
+			logError(r, err)
+		}
+	}
+}
+
+// limitImageBody bounds the body of a request that may carry an image
+// by the room one takes, so that larger bodies are refused before they are read whole
+func limitImageBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageSize+maxFormSize)
+}
+
+// parseMultipartForm parses the form of a request that may carry an image,
+// refusing bodies too large for one behind limitImageBody.
+// Other forms are parsed as usual
+func parseMultipartForm(r *http.Request) error {
+	err := r.ParseMultipartForm(maxImageSize + maxFormSize)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil, err == http.ErrNotMultipart:
+		return nil
+	case errors.As(err, &tooLarge):
+		return errImageTooLarge
+	default:
+		return validationError{
+			fields:  []string{"image"},
+			message: "The image cannot be read",
+		}
+	}
+}
+
+// parseImage checks the image in the "image" field of a multipart form
+// parsed by parseMultipartForm, and makes its thumbnail.
+// The type of the image is sniffed, what the client says is ignored
+// return nil, nil if no image was sent
+func parseImage(r *http.Request) (*upload, error) {
+	if r.MultipartForm == nil || len(r.MultipartForm.File["image"]) == 0 {
+		return nil, nil
+	}
+	file := r.MultipartForm.File["image"][0]
+	if file.Size == 0 {
+		// Browsers send an empty file when none is chosen
+		return nil, nil
+	}
+	if file.Size > maxImageSize {
+		return nil, errImageTooLarge
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := http.DetectContentType(data)
+	if _, ok := imageExtensions[contentType]; !ok {
+		return nil, validationError{
+			fields:  []string{"image"},
+			message: "Images must be JPEG, PNG or GIF",
+		}
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, validationError{
+			fields:  []string{"image"},
+			message: "The image cannot be read",
+		}
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, validationError{
+			fields:  []string{"image"},
+			message: "Images must be at most %d megapixels",
+			args:    []interface{}{maxImagePixels / 1000000},
+		}
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, validationError{
+			fields:  []string{"image"},
+			message: "The image cannot be read",
+		}
+	}
+	thumbnail, err := makeThumbnail(img)
+	if err != nil {
+		return nil, err
+	}
+	return &upload{
+		data:        data,
+		contentType: contentType,
+		thumbnail:   thumbnail,
+	}, nil
+}
+
+// makeThumbnail scales an image down to fit in a square
+// of thumbnailSize pixels, and encodes it as a JPEG
+func makeThumbnail(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	switch {
+	case width <= thumbnailSize && height <= thumbnailSize:
+		// Small enough already
+	case width >= height:
+		height = height * thumbnailSize / width
+		width = thumbnailSize
+	default:
+		width = width * thumbnailSize / height
+		height = thumbnailSize
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	// JPEG has no transparency, transparent pixels turn white
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// newImageName generates a random name for the blobs of an image
+func newImageName() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,402 @@
+package http
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	app "useritem"
+)
+
+const (
+	// maxImportSize is the size of the largest file users can import, in bytes
+	maxImportSize = 10 << 20
+	// maxImportItems is how many items a file can hold
+	maxImportItems = 10000
+	// maxImportLineSize is the size of the longest line of a JSON Lines file, in bytes
+	maxImportLineSize = 64 << 10
+	// exportPageSize is how many items exports read at a time
+	exportPageSize = 500
+)
+
+// Formats of the files items are imported from and exported to
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+var (
+	errImportTooLarge = validationError{
+		fields:  []string{"file"},
+		message: "Files must be at most %d MB",
+		args:    []interface{}{maxImportSize >> 20},
+	}
+	errImportFormat = validationError{
+		fields:  []string{"format"},
+		message: "Format must be csv or jsonl",
+	}
+	errImportEmpty = validationError{
+		fields:  []string{"file"},
+		message: "The file has no item",
+	}
+)
+
+// importFile is a file of items sent by an user
+type importFile struct {
+	Format string
+	Body   io.ReadCloser
+	// DryRun only checks the items, nothing is imported
+	DryRun bool
+}
+
+// itemImport is the outcome of an import
+type itemImport struct {
+	DryRun bool
+	// Items are the items imported, or that would be on a dry run
+	Items []app.Item
+	// Errors tell what is wrong with the lines of the file.
+	// Nothing is imported when there are any
+	Errors []importError
+}
+
+// importError is what is wrong with a line of an imported file
+type importError struct {
+	Line int
+	Err  validationError
+}
+
+// itemRecord is an item in imported and exported files
+type itemRecord struct {
+	ID    int      `json:"id,omitempty"`
+	Name  string   `json:"name"`
+	Price int      `json:"price"`
+	Tags  []string `json:"tags"`
+}
+
+// csvColumns are the columns of exported CSV files.
+// Imported files need name and price, in any order, tags are optional
+// and other columns are ignored
+var csvColumns = []string{"id", "name", "price", "tags"}
+
+// Export sends all items of an user as a file, in the ?format= it asks for:
+// csv, the default, or jsonl. Like on Index, ?user=<id> exports another user's items
+func (h *ItemHandler) Export(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	userID, err := listedUserID(r)
+	if err != nil {
+		rd.renderIndexError(w, r, err)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatCSV
+	}
+	if format != formatCSV && format != formatJSONL {
+		rd.renderIndexError(w, r, errImportFormat)
+		return
+	}
+	items, err := h.itemRepo.ByUserAfter(r.Context(), userID, 0, exportPageSize)
+	if err != nil {
+		logError(r, err)
+		rd.renderIndexError(w, r, err)
+		return
+	}
+
+	header := w.Header()
+	if format == formatCSV {
+		header.Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		header.Set("Content-Type", "application/x-ndjson")
+	}
+	header.Set("Content-Disposition", `attachment; filename="items.`+format+`"`)
+	// Items are written a page at a time as they are read:
+	// once headers are sent, errors can only be logged
+	var write func([]app.Item) error
+	if format == formatCSV {
+		write, err = csvItemWriter(w)
+	} else {
+		write = jsonlItemWriter(w)
+	}
+	for err == nil && len(items) > 0 {
+		err = write(items)
+		if err != nil || len(items) < exportPageSize {
+			break
+		}
+		items, err = h.itemRepo.ByUserAfter(r.Context(), userID, items[len(items)-1].ID, exportPageSize)
+	}
+	if err != nil {
+		logError(r, err)
+	}
+}
+
+// ShowImport shows the form to import items from a file
+func (h *ItemHandler) ShowImport(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	rd.renderImportForm(w, r)
+}
+
+// Import creates items for the current user from a CSV or JSON Lines file.
+// Every item is checked like on Create, and either all of them are created
+// or none, when any line is wrong. A dry run only checks them
+func (h *ItemHandler) Import(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	user := currentUser(r)
+	if h.requireVerifiedEmail && !user.EmailVerified {
+		rd.renderImportError(w, r, errEmailNotVerified)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize+maxFormSize)
+	file, err := rd.parseImport(r)
+	if err != nil {
+		rd.renderImportError(w, r, err)
+		return
+	}
+	defer file.Body.Close()
+
+	res := itemImport{DryRun: file.DryRun}
+	res.Items, res.Errors, err = readItems(file)
+	if err == nil && len(res.Items) == 0 && len(res.Errors) == 0 {
+		err = errImportEmpty
+	}
+	if err != nil {
+		rd.renderImportError(w, r, err)
+		return
+	}
+	for i := range res.Items {
+		res.Items[i].UserID = user.ID
+	}
+	if len(res.Errors) > 0 || res.DryRun {
+		rd.renderImport(w, r, res)
+		return
+	}
+
+	err = h.itemRepo.CreateAll(r.Context(), res.Items)
+	if err != nil {
+		logError(r, err)
+		rd.renderImportError(w, r, err)
+		return
+	}
+	rd.renderImport(w, r, res)
+}
+
+// readItems reads and checks the items of a file
+// return the valid items, what is wrong with the others, and an error
+// if the file as a whole cannot be imported
+func readItems(file *importFile) ([]app.Item, []importError, error) {
+	var next func() (app.Item, int, error)
+	switch file.Format {
+	case formatCSV:
+		var err error
+		next, err = csvItemReader(file.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+	case formatJSONL:
+		next = jsonlItemReader(file.Body)
+	default:
+		return nil, nil, errImportFormat
+	}
+
+	var items []app.Item
+	var errs []importError
+	for count := 0; ; count++ {
+		item, line, err := next()
+		if err == io.EOF {
+			break
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, nil, errImportTooLarge
+		}
+		if count == maxImportItems {
+			return nil, nil, validationError{
+				fields:  []string{"file"},
+				message: "Files can hold at most %d items",
+				args:    []interface{}{maxImportItems},
+			}
+		}
+		if err == nil {
+			err = validateItem(&item)
+		}
+		if v, ok := err.(validationError); ok {
+			errs = append(errs, importError{Line: line, Err: v})
+			continue
+		}
+		if err != nil {
+			// The rest of the file cannot be read
+			errs = append(errs, importError{Line: line, Err: validationError{
+				message: "This line cannot be read",
+			}})
+			break
+		}
+		items = append(items, item)
+	}
+	return items, errs, nil
+}
+
+// csvItemReader reads the header of a CSV file
+// return a function reading its items one at a time,
+// along their line, until io.EOF
+func csvItemReader(body io.Reader) (func() (app.Item, int, error), error) {
+	cr := csv.NewReader(body)
+	// Lines may be short, their missing columns are empty
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errImportEmpty
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, errImportTooLarge
+		}
+		return nil, validationError{
+			fields:  []string{"file"},
+			message: "The file is not a valid CSV file",
+		}
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		// Spreadsheets may start files with a byte order mark
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	_, hasName := columns["name"]
+	_, hasPrice := columns["price"]
+	if !hasName || !hasPrice {
+		return nil, validationError{
+			fields:  []string{"file"},
+			message: "The file must have name and price columns",
+		}
+	}
+
+	return func() (app.Item, int, error) {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return app.Item{}, 0, err
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return app.Item{}, parseErr.Line, err
+			}
+			return app.Item{}, 0, err
+		}
+		line, _ := cr.FieldPos(0)
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		item := app.Item{
+			Name: value("name"),
+			Tags: normalizeTags(strings.Split(value("tags"), ",")),
+		}
+		item.Price, err = strconv.Atoi(value("price"))
+		if err != nil {
+			return app.Item{}, line, validationError{
+				fields:  []string{"price"},
+				message: "Price must be a number",
+			}
+		}
+		return item, line, nil
+	}, nil
+}
+
+// jsonlItemReader returns a function reading the items of
+// a JSON Lines file one at a time, along their line, until io.EOF.
+// Blank lines are skipped
+func jsonlItemReader(body io.Reader) func() (app.Item, int, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLineSize)
+	line := 0
+	return func() (app.Item, int, error) {
+		for scanner.Scan() {
+			line++
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var record itemRecord
+			err := json.Unmarshal(scanner.Bytes(), &record)
+			var typeErr *json.UnmarshalTypeError
+			switch {
+			case errors.As(err, &typeErr) && typeErr.Field == "price":
+				return app.Item{}, line, validationError{
+					fields:  []string{"price"},
+					message: "Price must be a number",
+				}
+			case err != nil:
+				return app.Item{}, line, validationError{
+					message: "This line is not a valid item",
+				}
+			}
+			return app.Item{
+				Name:  record.Name,
+				Price: record.Price,
+				Tags:  normalizeTags(record.Tags),
+			}, line, nil
+		}
+		err := scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+		return app.Item{}, line + 1, err
+	}
+}
+
+// csvItemWriter writes a header naming the columns of items as CSV
+// return a function writing pages of items under it. Tags are joined by commas
+func csvItemWriter(w io.Writer) (func([]app.Item) error, error) {
+	cw := csv.NewWriter(w)
+	err := cw.Write(csvColumns)
+	if err != nil {
+		return nil, err
+	}
+	// Files without items still get the header
+	cw.Flush()
+	err = cw.Error()
+	if err != nil {
+		return nil, err
+	}
+	return func(items []app.Item) error {
+		for _, item := range items {
+			err := cw.Write([]string{
+				strconv.Itoa(item.ID),
+				item.Name,
+				strconv.Itoa(item.Price),
+				strings.Join(item.Tags, ", "),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}, nil
+}
+
+// jsonlItemWriter returns a function writing pages of items
+// as JSON Lines, an object per line
+func jsonlItemWriter(w io.Writer) func([]app.Item) error {
+	enc := json.NewEncoder(w)
+	return func(items []app.Item) error {
+		for _, item := range items {
+			err := enc.Encode(itemRecord{
+				ID:    item.ID,
+				Name:  item.Name,
+				Price: item.Price,
+				Tags:  jsonTags(item.Tags),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
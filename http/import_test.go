@@ -0,0 +1,68 @@
+package http
+
+import (
+	gocontext "context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	app "useritem"
+	"useritem/context"
+)
+
+// pagedItemRepo serves items a page at a time, like the database does
+type pagedItemRepo struct {
+	app.ItemRepo
+	items []app.Item
+}
+
+func (repo *pagedItemRepo) ByUserAfter(ctx gocontext.Context, userID, afterID, limit int) ([]app.Item, error) {
+	var page []app.Item
+	for _, item := range repo.items {
+		if item.ID > afterID && len(page) < limit {
+			page = append(page, item)
+		}
+	}
+	return page, nil
+}
+
+func export(t *testing.T, items []app.Item, format string) *httptest.ResponseRecorder {
+	t.Helper()
+	h := &ItemHandler{itemRepo: &pagedItemRepo{items: items}}
+	r := httptest.NewRequest(http.MethodGet, "/items/export?format="+format, nil)
+	ctx := context.WithUser(r.Context(), &app.User{ID: 1})
+	ctx = gocontext.WithValue(ctx, rendererKey{}, jsonRenderer(Config{}, APIv1))
+	w := httptest.NewRecorder()
+	h.Export(w, r.WithContext(ctx))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	return w
+}
+
+func TestExportEmpty(t *testing.T) {
+	if got, want := export(t, nil, formatCSV).Body.String(), "id,name,price,tags\n"; got != want {
+		t.Errorf("CSV = %q, want %q", got, want)
+	}
+	if got := export(t, nil, formatJSONL).Body.String(); got != "" {
+		t.Errorf("JSON Lines = %q, want nothing", got)
+	}
+}
+
+func TestExportPages(t *testing.T) {
+	items := make([]app.Item, exportPageSize*2+1)
+	for i := range items {
+		items[i] = app.Item{ID: i + 1, Name: "item", Price: i, Tags: []string{"a", "b"}}
+	}
+	lines := strings.Split(strings.TrimSuffix(export(t, items, formatCSV).Body.String(), "\n"), "\n")
+	if len(lines) != len(items)+1 {
+		t.Fatalf("CSV has %d lines, want %d", len(lines), len(items)+1)
+	}
+	if got, want := lines[len(lines)-1], `1001,item,1000,"a, b"`; got != want {
+		t.Errorf("last line = %q, want %q", got, want)
+	}
+	lines = strings.Split(strings.TrimSuffix(export(t, items, formatJSONL).Body.String(), "\n"), "\n")
+	if len(lines) != len(items) {
+		t.Fatalf("JSON Lines has %d lines, want %d", len(lines), len(items))
+	}
+}
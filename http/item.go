@@ -1,75 +1,363 @@
-package http
-
-import (
-	"log"
-	"net/http"
-	app "useritem"
-	"useritem/context"
-)
-
-// ItemHandler handles item related stuffs
-type ItemHandler struct {
-	itemRepo app.ItemRepo
-
-	renderNew func(http.ResponseWriter)
-
-	parseItem           func(*http.Request) (*app.Item, error)
-	renderCreateSuccess func(http.ResponseWriter, *http.Request, *app.Item)
-	renderCreateError   func(http.ResponseWriter, *http.Request, error)
-
-	renderIndexSuccess func(http.ResponseWriter, *http.Request, []app.Item) error
-	renderIndexError   func(http.ResponseWriter, *http.Request, error)
-}
-
-// Index shows all items of an user
-func (h *ItemHandler) Index(w http.ResponseWriter, r *http.Request) {
-	user := context.User(r.Context())
-
-	// Query for this user's items
-	items, err := h.itemRepo.ByUser(user.ID)
-
-	// Render the items
-	if err != nil {
-		h.renderIndexError(w, r, err)
-		return
-	}
-
-	err = h.renderIndexSuccess(w, r, items)
-	if err != nil {
-		log.Println(err)
-		h.renderIndexError(w, r, err)
-	}
-}
-
-// Create puts new item into item repo
-func (h *ItemHandler) Create(w http.ResponseWriter, r *http.Request) {
-
-	// Parse item and validate data
-	item, err := h.parseItem(r)
-	if err != nil {
-		h.renderCreateError(w, r, err)
-		return
-	}
-	if item.Price > 100000 {
-		h.renderCreateError(w, r, validationError{
-			fields:  []string{"price"},
-			message: "Price must be at most 100,000",
-		})
-		return
-	}
-
-	// Push new item into repo
-	err = h.itemRepo.Create(item)
-	if err != nil {
-		log.Println(err)
-		h.renderCreateError(w, r, err)
-		return
-	}
-	h.renderCreateSuccess(w, r, item)
-}
-
-// New shows create new item page
-func (h *ItemHandler) New(w http.ResponseWriter, r *http.Request) {
-	// Ignore auth for now - do it on the POST
-	h.renderNew(w)
-}
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	app "useritem"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxItemPrice is the highest price of an item, in VND
+	maxItemPrice = 100000
+	// itemSearchPageSize is how many items a page of search results has
+	itemSearchPageSize = 20
+)
+
+var (
+	errForbidden = errors.New("http: not allowed")
+)
+
+// ItemHandler handles item related stuffs
+type ItemHandler struct {
+	itemRepo app.ItemRepo
+	// blobStore keeps the images of items
+	blobStore app.BlobStore
+
+	// requireVerifiedEmail forbids creating items
+	// until the user has verified their email
+	requireVerifiedEmail bool
+
+	// maxBatchSize is how many items a batch can act on
+	maxBatchSize int
+}
+
+// itemRenderer parses the requests and renders the responses of ItemHandler
+// in the format of a Renderer
+type itemRenderer struct {
+	renderNew  func(w http.ResponseWriter, r *http.Request, tagSuggestions []string)
+	renderEdit func(w http.ResponseWriter, r *http.Request, item *app.Item, tagSuggestions []string)
+
+	parseItem           func(*http.Request) (*app.Item, error)
+	renderCreateSuccess func(http.ResponseWriter, *http.Request, *app.Item)
+	renderCreateError   func(http.ResponseWriter, *http.Request, error)
+
+	renderIndexSuccess func(http.ResponseWriter, *http.Request, []app.Item) error
+	renderIndexError   func(http.ResponseWriter, *http.Request, error)
+
+	renderShow      func(http.ResponseWriter, *http.Request, *app.Item)
+	renderShowError func(http.ResponseWriter, *http.Request, error)
+
+	renderUpdateSuccess func(http.ResponseWriter, *http.Request, *app.Item)
+	renderUpdateError   func(http.ResponseWriter, *http.Request, error)
+
+	renderDeleteSuccess func(http.ResponseWriter, *http.Request, *app.Item)
+	renderDeleteError   func(http.ResponseWriter, *http.Request, error)
+
+	renderTags      func(http.ResponseWriter, *http.Request, []string)
+	renderTagsError func(http.ResponseWriter, *http.Request, error)
+
+	renderSearch func(http.ResponseWriter, *http.Request, itemSearch)
+
+	renderImportForm  func(http.ResponseWriter, *http.Request)
+	parseImport       func(*http.Request) (*importFile, error)
+	renderImport      func(http.ResponseWriter, *http.Request, itemImport)
+	renderImportError func(http.ResponseWriter, *http.Request, error)
+
+	parseBatch       func(*http.Request) ([]app.Item, error)
+	parseBatchIDs    func(*http.Request) ([]int, error)
+	renderBatch      func(http.ResponseWriter, *http.Request, []batchResult)
+	renderBatchError func(http.ResponseWriter, *http.Request, error)
+
+	renderHistory func(http.ResponseWriter, *http.Request, itemHistory)
+}
+
+// itemSearch is a page of the items of an user matching a search
+type itemSearch struct {
+	Query   string
+	UserID  int
+	Matches []app.ItemMatch
+	Page    int
+	// HasNext tells whether there are matches after this page
+	HasNext bool
+}
+
+// Index shows all items of an user, or only those with ?tag=<tag>.
+// Users allowed to manage items can see another user's items with ?user=<id>
+func (h *ItemHandler) Index(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	userID, err := listedUserID(r)
+	if err != nil {
+		rd.renderIndexError(w, r, err)
+		return
+	}
+
+	// Query for this user's items
+	var items []app.Item
+	if tag := normalizeTag(r.URL.Query().Get("tag")); tag != "" {
+		items, err = h.itemRepo.ByTag(r.Context(), userID, tag)
+	} else {
+		items, err = h.itemRepo.ByUser(r.Context(), userID)
+	}
+
+	// Render the items
+	if err != nil {
+		rd.renderIndexError(w, r, err)
+		return
+	}
+
+	err = rd.renderIndexSuccess(w, r, items)
+	if err != nil {
+		logError(r, err)
+		rd.renderIndexError(w, r, err)
+	}
+}
+
+// Search looks for the items of an user matching ?q=, a page at a time
+// with ?page=. Like on Index, ?user=<id> searches another user's items
+func (h *ItemHandler) Search(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	userID, err := listedUserID(r)
+	if err != nil {
+		rd.renderIndexError(w, r, err)
+		return
+	}
+	search := itemSearch{
+		Query:  r.URL.Query().Get("q"),
+		UserID: userID,
+		Page:   1,
+	}
+	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && page > 1 {
+		search.Page = page
+	}
+
+	// Ask one more match than needed to know if there is a next page
+	matches, err := h.itemRepo.Search(r.Context(), userID, search.Query,
+		itemSearchPageSize+1, (search.Page-1)*itemSearchPageSize)
+	if err != nil {
+		logError(r, err)
+		rd.renderIndexError(w, r, err)
+		return
+	}
+	if len(matches) > itemSearchPageSize {
+		matches = matches[:itemSearchPageSize]
+		search.HasNext = true
+	}
+	search.Matches = matches
+	rd.renderSearch(w, r, search)
+}
+
+// Create puts new item into item repo
+func (h *ItemHandler) Create(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	user := currentUser(r)
+	if h.requireVerifiedEmail && !user.EmailVerified {
+		rd.renderCreateError(w, r, errEmailNotVerified)
+		return
+	}
+
+	// Parse item and validate data
+	limitImageBody(w, r)
+	item, err := rd.parseItem(r)
+	if err != nil {
+		rd.renderCreateError(w, r, err)
+		return
+	}
+	item.UserID = user.ID
+	err = validateItem(item)
+	if err != nil {
+		rd.renderCreateError(w, r, err)
+		return
+	}
+	upload, err := parseImage(r)
+	if err != nil {
+		rd.renderCreateError(w, r, err)
+		return
+	}
+	if upload != nil {
+		item.Image, err = h.storeImage(r.Context(), upload)
+		if err != nil {
+			logError(r, err)
+			rd.renderCreateError(w, r, err)
+			return
+		}
+	}
+
+	// Push new item into repo
+	err = h.itemRepo.Create(r.Context(), item)
+	if err != nil {
+		logError(r, err)
+		h.deleteImage(r, item.Image)
+		rd.renderCreateError(w, r, err)
+		return
+	}
+	rd.renderCreateSuccess(w, r, item)
+}
+
+// New shows create new item page
+func (h *ItemHandler) New(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	// Ignore auth for now - do it on the POST
+	rd.renderNew(w, r, h.tagSuggestions(r, currentUser(r).ID))
+}
+
+// Show shows an item
+func (h *ItemHandler) Show(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	item, err := h.itemFromRequest(r)
+	if err != nil {
+		rd.renderShowError(w, r, err)
+		return
+	}
+	rd.renderShow(w, r, item)
+}
+
+// Edit shows the form to change an item
+func (h *ItemHandler) Edit(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	item, err := h.itemFromRequest(r)
+	if err != nil {
+		rd.renderShowError(w, r, err)
+		return
+	}
+	rd.renderEdit(w, r, item, h.tagSuggestions(r, item.UserID))
+}
+
+// Update changes the name, price and tags of an item.
+// Tags are left as they are if the changes have none.
+// The image is replaced by the one in a multipart form, if any,
+// or removed if the form has remove_image
+func (h *ItemHandler) Update(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	item, err := h.itemFromRequest(r)
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+
+	// Parse changes and validate data
+	limitImageBody(w, r)
+	changes, err := rd.parseItem(r)
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	err = validateItem(changes)
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	upload, err := parseImage(r)
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	item.Name = changes.Name
+	item.Price = changes.Price
+	if changes.Tags != nil {
+		item.Tags = changes.Tags
+	}
+	old := item.Image
+	if upload != nil {
+		item.Image, err = h.storeImage(r.Context(), upload)
+		if err != nil {
+			logError(r, err)
+			rd.renderUpdateError(w, r, err)
+			return
+		}
+	} else if r.MultipartForm != nil && r.MultipartForm.Value["remove_image"] != nil {
+		item.Image = nil
+	}
+
+	err = h.itemRepo.Update(r.Context(), item)
+	if err != nil {
+		logError(r, err)
+		if item.Image != old {
+			h.deleteImage(r, item.Image)
+		}
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	if item.Image != old {
+		h.deleteImage(r, old)
+	}
+	rd.renderUpdateSuccess(w, r, item)
+}
+
+// Delete removes an item from item repo
+func (h *ItemHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	item, err := h.itemFromRequest(r)
+	if err != nil {
+		rd.renderDeleteError(w, r, err)
+		return
+	}
+	err = h.itemRepo.Delete(r.Context(), item.ID)
+	if err != nil {
+		logError(r, err)
+		rd.renderDeleteError(w, r, err)
+		return
+	}
+	h.deleteImage(r, item.Image)
+	rd.renderDeleteSuccess(w, r, item)
+}
+
+// listedUserID returns the id of the user whose items are listed:
+// the current user, or the one in ?user= for users allowed to manage items
+func listedUserID(r *http.Request) (int, error) {
+	user := currentUser(r)
+	s := r.URL.Query().Get("user")
+	if s == "" {
+		return user.ID, nil
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, app.ErrNotFound
+	}
+	if id != user.ID && !user.Can(app.PermManageItems) {
+		return 0, errForbidden
+	}
+	return id, nil
+}
+
+// itemFromRequest looks up the item whose id is in the URL
+// and checks that the current user may act on it:
+// users act on their own items, and on anybody's
+// if they are allowed to manage items
+func (h *ItemHandler) itemFromRequest(r *http.Request) (*app.Item, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, app.ErrNotFound
+	}
+	return h.itemForUser(r, id)
+}
+
+// itemForUser looks up an item and checks that
+// the current user may act on it, like itemFromRequest
+func (h *ItemHandler) itemForUser(r *http.Request, id int) (*app.Item, error) {
+	item, err := h.itemRepo.ByID(r.Context(), id)
+	if err != nil {
+		if err != app.ErrNotFound {
+			logError(r, err)
+		}
+		return nil, err
+	}
+	user := currentUser(r)
+	if item.UserID != user.ID && !user.Can(app.PermManageItems) {
+		return nil, errForbidden
+	}
+	return item, nil
+}
+
+func validateItem(item *app.Item) error {
+	if item.Price > maxItemPrice {
+		return validationError{
+			fields:  []string{"price"},
+			message: "Price must be at most %d",
+			args:    []interface{}{maxItemPrice},
+		}
+	}
+	return validateTags(item.Tags)
+}
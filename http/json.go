@@ -2,17 +2,21 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 	app "useritem"
 	"useritem/context"
+	"useritem/i18n"
 
 	"golang.org/x/oauth2"
 )
 
 func renderJSON(w http.ResponseWriter, data interface{}, status int) {
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	enc := json.NewEncoder(w)
 	enc.Encode(data)
@@ -27,11 +31,59 @@ func (e jsonError) Error() string {
 	return fmt.Sprintf("json %s error: %s", e.Type, e.Message)
 }
 
+type jsonMessage struct {
+	Message string `json:"message"`
+}
+
+func renderJSONInternalError(w http.ResponseWriter) {
+	renderJSON(w, jsonError{
+		Message: "Something went wrong. Try again later",
+		Type:    "internal_server",
+	}, http.StatusInternalServerError)
+}
+
+func renderJSONForbidden(w http.ResponseWriter) {
+	renderJSON(w, jsonError{
+		Message: "You are not allowed to do this",
+		Type:    "forbidden",
+	}, http.StatusForbidden)
+}
+
+func renderJSONAccountDisabled(w http.ResponseWriter) {
+	renderJSON(w, jsonError{
+		Message: "This account is disabled. Contact support",
+		Type:    "account_disabled",
+	}, http.StatusForbidden)
+}
+
+func renderJSONNotFound(w http.ResponseWriter) {
+	renderJSON(w, jsonError{
+		Message: "The requested resource is not found",
+		Type:    "not_found",
+	}, http.StatusNotFound)
+}
+
+// renderJSONValidationError renders a validation error.
+// Like its other messages, the JSON API renders it in the default language
+func renderJSONValidationError(w http.ResponseWriter, err validationError) {
+	renderJSON(w, struct {
+		Fields []string `json:"fields"`
+		jsonError
+	}{
+		Fields: err.fields,
+		jsonError: jsonError{
+			Message: err.localize(i18n.NewPrinter(i18n.Languages[0])),
+			Type:    "validation",
+		},
+	}, http.StatusBadRequest)
+}
+
 type jsonAuthMw struct {
-	userRepo app.UserRepo
+	userRepo        app.UserRepo
+	accessTokenRepo app.AccessTokenRepo
 }
 
-// SetUser retrieves a user from session
+// SetUser retrieves a user from session or access token
 // and put it into request context
 func (mw *jsonAuthMw) SetUser(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -41,13 +93,17 @@ func (mw *jsonAuthMw) SetUser(next http.Handler) http.HandlerFunc {
 			return
 		}
 		tokenStr := strings.TrimSpace(bearer[len("Bearer"):])
+		if strings.HasPrefix(tokenStr, accessTokenPrefix) {
+			mw.setAccessTokenUser(next, w, r, tokenStr)
+			return
+		}
 		token, err := strconv.Atoi(tokenStr)
 		if err != nil {
 			next.ServeHTTP(w, r)
 			return
 		}
-		user, err := mw.userRepo.ByToken(token)
-		if err != nil {
+		user, err := mw.userRepo.ByToken(r.Context(), token)
+		if err != nil || user.Disabled {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -56,26 +112,102 @@ func (mw *jsonAuthMw) SetUser(next http.Handler) http.HandlerFunc {
 	}
 }
 
+// setAccessTokenUser retrieves a user from an access token
+// and put both into request context
+func (mw *jsonAuthMw) setAccessTokenUser(next http.Handler, w http.ResponseWriter, r *http.Request, secret string) {
+	token, err := mw.accessTokenRepo.ByHash(r.Context(), hashSecret(secret))
+	if err != nil || token.Expired(time.Now()) {
+		next.ServeHTTP(w, r)
+		return
+	}
+	user, err := mw.userRepo.ByID(r.Context(), token.UserID)
+	if err != nil || user.Disabled {
+		next.ServeHTTP(w, r)
+		return
+	}
+	ctx := context.WithUser(r.Context(), user)
+	ctx = context.WithAccessToken(ctx, token)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// RequireScope requires the access token of a request to have a scope
+func (mw *jsonAuthMw) RequireScope(scope app.Scope) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !hasScope(r, scope) {
+				renderJSON(w, jsonError{
+					Message: fmt.Sprintf("This access token is not granted the %q scope", scope),
+					Type:    "insufficient_scope",
+				}, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// RequireRole requires the user of a request to have a role
+func (mw *jsonAuthMw) RequireRole(role app.Role) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !hasRole(r, role) {
+				renderJSONForbidden(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// RequirePermission requires the user of a request to have a permission
+func (mw *jsonAuthMw) RequirePermission(perm app.Permission) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !hasPermission(r, perm) {
+				renderJSONForbidden(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
 // RequireUser requires a user from context
 // if no user found, redirect to sign in
 func (mw *jsonAuthMw) RequireUser(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		user := context.User(r.Context())
-		if user == nil {
+		if optionalUser(r) == nil {
 			renderJSON(w, jsonError{
 				Message: "Unauthorized access. Do you have a valid oath2 token set ?",
 				Type:    "unauthorized",
 			}, http.StatusUnauthorized)
 			return
 		}
+		setPrivate(w)
 		next.ServeHTTP(w, r)
 	}
 }
 
-func jsonUserHandler(userRepo app.UserRepo) *UserHandler {
-	uh := UserHandler{
-		userRepo: userRepo,
+// jsonRenderer returns the renderer of a version of the JSON API
+func jsonRenderer(cfg Config, version APIVersion) *Renderer {
+	v := lookupAPIVersion(version)
+	return &Renderer{
+		auth: &jsonAuthMw{
+			userRepo:        cfg.UserRepo,
+			accessTokenRepo: cfg.AccessTokenRepo,
+		},
+		users:        jsonUserRenderer(),
+		items:        v.itemRenderer(),
+		accessTokens: jsonAccessTokenRenderer(),
+		internalError: func(w http.ResponseWriter, r *http.Request) {
+			renderJSONInternalError(w)
+		},
+		spec: v.spec,
+	}
+}
 
+func jsonUserRenderer() *userRenderer {
+	uh := userRenderer{
 		parseEmailAndPassword: func(r *http.Request) (email, password string) {
 			var req struct {
 				Email    string `json:"email"`
@@ -99,6 +231,8 @@ func jsonUserHandler(userRepo app.UserRepo) *UserHandler {
 					Message: "Invalid authentication details",
 					Type:    "authentication",
 				}, http.StatusBadRequest)
+			case errAccountDisabled:
+				renderJSONAccountDisabled(w)
 			default:
 				renderJSON(w, jsonError{
 					Message: "Something went wrong. Try again later",
@@ -106,28 +240,334 @@ func jsonUserHandler(userRepo app.UserRepo) *UserHandler {
 				}, http.StatusInternalServerError)
 			}
 		},
+		parseEmail: func(r *http.Request) string {
+			var req struct {
+				Email string `json:"email"`
+			}
+			dec := json.NewDecoder(r.Body)
+			dec.Decode(&req)
+			return req.Email
+		},
+		renderProcessForgotPasswordSuccess: func(w http.ResponseWriter, r *http.Request) {
+			renderJSON(w, jsonMessage{
+				Message: "If an account exists for this email address, a link to reset its password is on its way",
+			}, http.StatusAccepted)
+		},
+		renderProcessForgotPasswordError: func(w http.ResponseWriter, r *http.Request, err error) {
+			renderJSON(w, jsonError{
+				Message: "Something went wrong. Try again later",
+				Type:    "internal_server",
+			}, http.StatusInternalServerError)
+		},
+		parseTokenAndPassword: func(r *http.Request) (token, password string) {
+			var req struct {
+				Token    string `json:"token"`
+				Password string `json:"password"`
+			}
+			dec := json.NewDecoder(r.Body)
+			dec.Decode(&req)
+			return req.Token, req.Password
+		},
+		renderProcessResetPasswordSuccess: func(w http.ResponseWriter, r *http.Request) {
+			renderJSON(w, jsonMessage{
+				Message: "Your password has been reset",
+			}, http.StatusOK)
+		},
+		renderProcessResetPasswordError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderJSONValidationError(w, v)
+			default:
+				switch err {
+				case errInvalidToken:
+					renderJSON(w, jsonError{
+						Message: "The token is invalid or has expired",
+						Type:    "invalid_token",
+					}, http.StatusBadRequest)
+				default:
+					renderJSON(w, jsonError{
+						Message: "Something went wrong. Try again later",
+						Type:    "internal_server",
+					}, http.StatusInternalServerError)
+				}
+			}
+		},
+		parseSignup: func(r *http.Request) (name, email, password string) {
+			var req struct {
+				Name     string `json:"name"`
+				Email    string `json:"email"`
+				Password string `json:"password"`
+			}
+			dec := json.NewDecoder(r.Body)
+			dec.Decode(&req)
+			return req.Name, req.Email, req.Password
+		},
+		renderProcessSignupError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderJSONValidationError(w, v)
+			default:
+				switch err {
+				case errEmailTaken:
+					renderJSON(w, jsonError{
+						Message: "This email address is already taken",
+						Type:    "conflict",
+					}, http.StatusConflict)
+				default:
+					renderJSONInternalError(w)
+				}
+			}
+		},
+		renderAccount: func(w http.ResponseWriter, r *http.Request, user *app.User) {
+			var res jsonUser
+			res.read(*user)
+			renderJSON(w, res, http.StatusOK)
+		},
+		renderProcessChangeEmailSuccess: func(w http.ResponseWriter, r *http.Request) {
+			renderJSON(w, jsonMessage{
+				Message: "Your email address has been changed, check your inbox to verify it",
+			}, http.StatusOK)
+		},
+		renderProcessChangeEmailError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderJSONValidationError(w, v)
+			default:
+				switch err {
+				case errAuthFailed:
+					renderJSON(w, jsonError{
+						Message: "Invalid authentication details",
+						Type:    "authentication",
+					}, http.StatusBadRequest)
+				case errEmailTaken:
+					renderJSON(w, jsonError{
+						Message: "This email address is already taken",
+						Type:    "conflict",
+					}, http.StatusConflict)
+				default:
+					renderJSONInternalError(w)
+				}
+			}
+		},
+		renderVerifyEmailSuccess: func(w http.ResponseWriter, r *http.Request) {
+			renderJSON(w, jsonMessage{
+				Message: "Your email address is verified",
+			}, http.StatusOK)
+		},
+		renderVerifyEmailError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case errInvalidToken:
+				renderJSON(w, jsonError{
+					Message: "The token is invalid or has expired",
+					Type:    "invalid_token",
+				}, http.StatusBadRequest)
+			default:
+				renderJSONInternalError(w)
+			}
+		},
+		renderResendVerificationSuccess: func(w http.ResponseWriter, r *http.Request) {
+			renderJSON(w, jsonMessage{
+				Message: "A new verification mail is on its way",
+			}, http.StatusAccepted)
+		},
+		renderResendVerificationError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case errAlreadyVerified:
+				renderJSON(w, jsonError{
+					Message: "Your email address is already verified",
+					Type:    "already_verified",
+				}, http.StatusConflict)
+			default:
+				renderJSONInternalError(w)
+			}
+		},
+		renderTwoFactorChallenge: func(w http.ResponseWriter, r *http.Request, challenge string) {
+			renderJSON(w, struct {
+				Challenge string `json:"challenge"`
+				ExpiresIn int    `json:"expires_in"`
+				jsonMessage
+			}{
+				Challenge:   challenge,
+				ExpiresIn:   int(twoFactorTTL / time.Second),
+				jsonMessage: jsonMessage{Message: "Send the challenge with a two-factor code to /signin/2fa"},
+			}, http.StatusAccepted)
+		},
+		parseChallengeAndCode: func(r *http.Request) (challenge, code string) {
+			var req struct {
+				Challenge string `json:"challenge"`
+				Code      string `json:"code"`
+			}
+			dec := json.NewDecoder(r.Body)
+			dec.Decode(&req)
+			return req.Challenge, req.Code
+		},
+		renderProcessTwoFactorError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case errAccountDisabled:
+				renderJSONAccountDisabled(w)
+			case errInvalidToken:
+				renderJSON(w, jsonError{
+					Message: "The challenge is invalid or has expired, sign in again",
+					Type:    "invalid_token",
+				}, http.StatusBadRequest)
+			case errInvalidCode:
+				renderJSON(w, jsonError{
+					Message: "Invalid two-factor code, sign in again",
+					Type:    "authentication",
+				}, http.StatusBadRequest)
+			default:
+				renderJSONInternalError(w)
+			}
+		},
+		renderTwoFactorSetup: func(w http.ResponseWriter, r *http.Request, setup twoFactorSetup) {
+			renderJSON(w, struct {
+				Secret string `json:"secret"`
+				URL    string `json:"otpauth_url"`
+				QRCode []byte `json:"qr_code_png"`
+			}{
+				Secret: setup.Secret,
+				URL:    setup.URL,
+				QRCode: setup.QRCode,
+			}, http.StatusOK)
+		},
+		renderTwoFactorSetupError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case errTwoFactorEnabled:
+				renderJSON(w, jsonError{
+					Message: "Two-factor authentication is already enabled",
+					Type:    "conflict",
+				}, http.StatusConflict)
+			default:
+				renderJSONInternalError(w)
+			}
+		},
+		parseCode: func(r *http.Request) string {
+			var req struct {
+				Code string `json:"code"`
+			}
+			dec := json.NewDecoder(r.Body)
+			dec.Decode(&req)
+			return req.Code
+		},
+		renderProcessTwoFactorSetupSuccess: func(w http.ResponseWriter, r *http.Request, recoveryCodes []string) {
+			renderJSON(w, struct {
+				RecoveryCodes []string `json:"recovery_codes"`
+			}{
+				RecoveryCodes: recoveryCodes,
+			}, http.StatusOK)
+		},
+		parseRole: func(r *http.Request) app.Role {
+			var req struct {
+				Role app.Role `json:"role"`
+			}
+			dec := json.NewDecoder(r.Body)
+			dec.Decode(&req)
+			return req.Role
+		},
+		renderUpdateRoleSuccess: func(w http.ResponseWriter, r *http.Request, user *app.User) {
+			var res jsonUser
+			res.read(*user)
+			renderJSON(w, res, http.StatusOK)
+		},
+		renderUpdateRoleError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderJSONValidationError(w, v)
+			default:
+				switch err {
+				case app.ErrNotFound:
+					renderJSONNotFound(w)
+				default:
+					renderJSONInternalError(w)
+				}
+			}
+		},
+		renderProcessTwoFactorSetupError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case errInvalidCode:
+				renderJSON(w, jsonError{
+					Message: "The code is not valid",
+					Type:    "validation",
+				}, http.StatusBadRequest)
+			case errTwoFactorEnabled:
+				renderJSON(w, jsonError{
+					Message: "Two-factor authentication is already enabled",
+					Type:    "conflict",
+				}, http.StatusConflict)
+			default:
+				renderJSONInternalError(w)
+			}
+		},
 	}
 	return &uh
 }
 
+type jsonUser struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Role          string `json:"role"`
+	TwoFactor     bool   `json:"two_factor_enabled"`
+}
+
+func (user *jsonUser) read(u app.User) {
+	user.ID = u.ID
+	user.Name = u.Name
+	user.Email = u.Email
+	user.EmailVerified = u.EmailVerified
+	user.Role = string(u.Role)
+	user.TwoFactor = u.TOTPEnabled
+}
+
 type jsonItem struct {
-	Name  string `json:"name"`
-	Price int    `json:"price"`
+	ID    int        `json:"id"`
+	Name  string     `json:"name"`
+	Price int        `json:"price"`
+	Tags  []string   `json:"tags"`
+	Image *jsonImage `json:"image"`
 }
 
 func (item *jsonItem) read(i app.Item) {
+	item.ID = i.ID
 	item.Name = i.Name
 	item.Price = i.Price
+	item.Tags = jsonTags(i.Tags)
+	item.Image = newJSONImage(i.Image)
+}
+
+// jsonImage describes the image of an item,
+// which is downloaded from /items/{id}/image
+type jsonImage struct {
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+func newJSONImage(image *app.Image) *jsonImage {
+	if image == nil {
+		return nil
+	}
+	return &jsonImage{
+		ContentType: image.ContentType,
+		Size:        image.Size,
+	}
 }
 
-func jsonItemHandler(itemRepo app.ItemRepo) *ItemHandler {
-	ih := ItemHandler{
-		itemRepo: itemRepo,
+// jsonTags renders no tags as an empty list rather than null
+func jsonTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
 
+func jsonItemRenderer() *itemRenderer {
+	ih := itemRenderer{
 		parseItem: func(r *http.Request) (*app.Item, error) {
 			var req struct {
-				Name  string `json:"name"`
-				Price int    `json:"price"`
+				Name  string   `json:"name"`
+				Price int      `json:"price"`
+				Tags  []string `json:"tags"`
 			}
 			dec := json.NewDecoder(r.Body)
 			err := dec.Decode(&req)
@@ -138,10 +578,15 @@ func jsonItemHandler(itemRepo app.ItemRepo) *ItemHandler {
 				}
 			}
 
-			return &app.Item{
+			item := app.Item{
 				Name:  req.Name,
 				Price: req.Price,
-			}, nil
+			}
+			// Updates leave tags alone when there are none in the request
+			if req.Tags != nil {
+				item.Tags = normalizeTags(req.Tags)
+			}
+			return &item, nil
 		},
 		renderCreateSuccess: func(w http.ResponseWriter, r *http.Request, item *app.Item) {
 			var res jsonItem
@@ -151,21 +596,20 @@ func jsonItemHandler(itemRepo app.ItemRepo) *ItemHandler {
 		renderCreateError: func(w http.ResponseWriter, r *http.Request, err error) {
 			switch v := err.(type) {
 			case validationError:
-				renderJSON(w, struct {
-					Fields []string `json:"fields"`
-					jsonError
-				}{
-					Fields: v.fields,
-					jsonError: jsonError{
-						Message: v.message,
-						Type:    "validation",
-					},
-				}, http.StatusBadRequest)
+				renderJSONValidationError(w, v)
 			default:
-				renderJSON(w, jsonError{
-					Message: "Something went wrong. Try again later",
-					Type:    "internal_server",
-				}, http.StatusInternalServerError)
+				switch err {
+				case errEmailNotVerified:
+					renderJSON(w, jsonError{
+						Message: "Verify your email address before creating items",
+						Type:    "email_not_verified",
+					}, http.StatusForbidden)
+				default:
+					renderJSON(w, jsonError{
+						Message: "Something went wrong. Try again later",
+						Type:    "internal_server",
+					}, http.StatusInternalServerError)
+				}
 			}
 		},
 		renderIndexSuccess: func(w http.ResponseWriter, r *http.Request, items []app.Item) error {
@@ -175,15 +619,455 @@ func jsonItemHandler(itemRepo app.ItemRepo) *ItemHandler {
 				ji.read(item)
 				res = append(res, ji)
 			}
+			w.Header().Set("Content-Type", "application/json")
 			enc := json.NewEncoder(w)
 			return enc.Encode(res)
 		},
 		renderIndexError: func(w http.ResponseWriter, r *http.Request, err error) {
-			renderJSON(w, jsonError{
-				Message: "Something went wrong. Try again later",
-				Type:    "internal_server",
-			}, http.StatusInternalServerError)
+			if v, ok := err.(validationError); ok {
+				renderJSONValidationError(w, v)
+				return
+			}
+			switch err {
+			case app.ErrNotFound:
+				renderJSONNotFound(w)
+			case errForbidden:
+				renderJSONForbidden(w)
+			default:
+				renderJSON(w, jsonError{
+					Message: "Something went wrong. Try again later",
+					Type:    "internal_server",
+				}, http.StatusInternalServerError)
+			}
+		},
+		renderShow: func(w http.ResponseWriter, r *http.Request, item *app.Item) {
+			var res jsonItem
+			res.read(*item)
+			renderJSON(w, res, http.StatusOK)
+		},
+		renderShowError: renderJSONItemError,
+		renderUpdateSuccess: func(w http.ResponseWriter, r *http.Request, item *app.Item) {
+			var res jsonItem
+			res.read(*item)
+			renderJSON(w, res, http.StatusOK)
+		},
+		renderUpdateError: renderJSONItemError,
+		renderDeleteSuccess: func(w http.ResponseWriter, r *http.Request, item *app.Item) {
+			w.WriteHeader(http.StatusNoContent)
+		},
+		renderDeleteError: renderJSONItemError,
+		renderTags: func(w http.ResponseWriter, r *http.Request, tags []string) {
+			renderJSON(w, struct {
+				Tags []string `json:"tags"`
+			}{tags}, http.StatusOK)
+		},
+		renderTagsError: func(w http.ResponseWriter, r *http.Request, err error) {
+			renderJSONInternalError(w)
+		},
+		renderSearch: func(w http.ResponseWriter, r *http.Request, search itemSearch) {
+			res := jsonItemSearch{
+				Matches: make([]jsonItemMatch, 0, len(search.Matches)),
+				Page:    search.Page,
+				HasNext: search.HasNext,
+			}
+			for _, match := range search.Matches {
+				var item jsonItem
+				item.read(match.Item)
+				res.Matches = append(res.Matches, jsonItemMatch{
+					Item:       item,
+					Highlights: jsonHighlights(match.Highlights),
+				})
+			}
+			renderJSON(w, res, http.StatusOK)
+		},
+		parseImport: func(r *http.Request) (*importFile, error) {
+			file := importFile{
+				Format: r.URL.Query().Get("format"),
+				Body:   r.Body,
+			}
+			if file.Format == "" {
+				file.Format = formatCSV
+			}
+			if s := r.URL.Query().Get("dry_run"); s != "" {
+				dryRun, err := strconv.ParseBool(s)
+				if err != nil {
+					return nil, validationError{
+						fields:  []string{"dry_run"},
+						message: "Dry run must be true or false",
+					}
+				}
+				file.DryRun = dryRun
+			}
+			return &file, nil
+		},
+		renderImport: func(w http.ResponseWriter, r *http.Request, res itemImport) {
+			if len(res.Errors) > 0 {
+				p := i18n.NewPrinter(i18n.Languages[0])
+				lines := make([]jsonImportError, 0, len(res.Errors))
+				for _, e := range res.Errors {
+					lines = append(lines, jsonImportError{
+						Line:    e.Line,
+						Fields:  e.Err.fields,
+						Message: e.Err.localize(p),
+					})
+				}
+				renderJSON(w, struct {
+					jsonError
+					Lines []jsonImportError `json:"lines"`
+				}{
+					jsonError: jsonError{
+						Message: "Some lines of the file are not valid, no item was imported",
+						Type:    "validation",
+					},
+					Lines: lines,
+				}, http.StatusBadRequest)
+				return
+			}
+			status := http.StatusCreated
+			if res.DryRun {
+				status = http.StatusOK
+			}
+			renderJSON(w, struct {
+				Count  int  `json:"count"`
+				DryRun bool `json:"dry_run"`
+			}{
+				Count:  len(res.Items),
+				DryRun: res.DryRun,
+			}, status)
+		},
+		parseBatch: func(r *http.Request) ([]app.Item, error) {
+			var req []struct {
+				ID    int      `json:"id"`
+				Name  string   `json:"name"`
+				Price int      `json:"price"`
+				Tags  []string `json:"tags"`
+			}
+			dec := json.NewDecoder(r.Body)
+			err := dec.Decode(&req)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, err
+			}
+			if err != nil {
+				return nil, validationError{
+					message: "The batch must be an array of items",
+				}
+			}
+			items := make([]app.Item, 0, len(req))
+			for _, v := range req {
+				item := app.Item{
+					ID:    v.ID,
+					Name:  v.Name,
+					Price: v.Price,
+				}
+				// Updates leave tags alone when there are none
+				if v.Tags != nil {
+					item.Tags = normalizeTags(v.Tags)
+				}
+				items = append(items, item)
+			}
+			return items, nil
+		},
+		parseBatchIDs: func(r *http.Request) ([]int, error) {
+			var ids []int
+			dec := json.NewDecoder(r.Body)
+			err := dec.Decode(&ids)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, err
+			}
+			if err != nil {
+				return nil, validationError{
+					message: "The batch must be an array of item ids",
+				}
+			}
+			return ids, nil
+		},
+		renderBatch: func(w http.ResponseWriter, r *http.Request, results []batchResult) {
+			renderJSON(w, jsonBatch(results, func(i app.Item) interface{} {
+				var item jsonItem
+				item.read(i)
+				return item
+			}), http.StatusOK)
+		},
+		renderHistory: func(w http.ResponseWriter, r *http.Request, history itemHistory) {
+			renderJSON(w, jsonHistory(history, func(i app.Item) interface{} {
+				var item jsonItem
+				item.read(i)
+				return item
+			}), http.StatusOK)
+		},
+		renderBatchError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderJSONValidationError(w, v)
+			default:
+				switch err {
+				case errEmailNotVerified:
+					renderJSON(w, jsonError{
+						Message: "Verify your email address before creating items",
+						Type:    "email_not_verified",
+					}, http.StatusForbidden)
+				default:
+					renderJSONInternalError(w)
+				}
+			}
+		},
+		renderImportError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderJSONValidationError(w, v)
+			default:
+				switch err {
+				case errEmailNotVerified:
+					renderJSON(w, jsonError{
+						Message: "Verify your email address before creating items",
+						Type:    "email_not_verified",
+					}, http.StatusForbidden)
+				default:
+					renderJSONInternalError(w)
+				}
+			}
 		},
 	}
 	return &ih
 }
+
+// jsonItemSearch is a page of search results.
+// Items are jsonItem or jsonV2Item depending on the API version
+type jsonItemSearch struct {
+	Matches []jsonItemMatch `json:"matches"`
+	Page    int             `json:"page"`
+	HasNext bool            `json:"has_next"`
+}
+
+type jsonItemMatch struct {
+	Item       interface{}     `json:"item"`
+	Highlights []jsonHighlight `json:"highlights"`
+}
+
+type jsonHighlight struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+func jsonHighlights(highlights []app.Highlight) []jsonHighlight {
+	res := make([]jsonHighlight, 0, len(highlights))
+	for _, h := range highlights {
+		res = append(res, jsonHighlight{Text: h.Text, Match: h.Match})
+	}
+	return res
+}
+
+// jsonBatchResults are the results of a batch,
+// in the order of the items of the request
+type jsonBatchResults struct {
+	Results []jsonBatchResult `json:"results"`
+}
+
+// jsonBatchResult is the outcome of the operation of a batch on an item:
+// the item on success, except for deletions, or an error.
+// Items are jsonItem or jsonV2Item depending on the API version
+type jsonBatchResult struct {
+	Status  int         `json:"status"`
+	ID      int         `json:"id,omitempty"`
+	Item    interface{} `json:"item,omitempty"`
+	Message string      `json:"error,omitempty"`
+	Type    string      `json:"type,omitempty"`
+	Fields  []string    `json:"fields,omitempty"`
+}
+
+// jsonBatch renders the results of a batch, with their items read by readItem
+func jsonBatch(results []batchResult, readItem func(app.Item) interface{}) jsonBatchResults {
+	res := jsonBatchResults{Results: make([]jsonBatchResult, 0, len(results))}
+	for _, result := range results {
+		jr := jsonBatchResult{
+			Status: result.Status,
+			ID:     result.ID,
+		}
+		if result.Err != nil {
+			jr.Status, jr.Message, jr.Type, jr.Fields = jsonBatchError(result.Err)
+		} else if result.Item != nil {
+			jr.Item = readItem(*result.Item)
+		}
+		res.Results = append(res.Results, jr)
+	}
+	return res
+}
+
+// jsonBatchError tells what renderJSONItemError would render for an error
+func jsonBatchError(err error) (status int, message, typ string, fields []string) {
+	if v, ok := err.(validationError); ok {
+		return http.StatusBadRequest, v.localize(i18n.NewPrinter(i18n.Languages[0])), "validation", v.fields
+	}
+	switch err {
+	case app.ErrNotFound:
+		return http.StatusNotFound, "The requested resource is not found", "not_found", nil
+	case errForbidden:
+		return http.StatusForbidden, "You are not allowed to do this", "forbidden", nil
+	default:
+		return http.StatusInternalServerError, "Something went wrong. Try again later", "internal_server", nil
+	}
+}
+
+// jsonItemHistory is the history of an item, newest changes first
+type jsonItemHistory struct {
+	ItemID  int              `json:"item_id"`
+	Changes []jsonItemChange `json:"changes"`
+}
+
+// jsonItemChange is a change of an item. Items are jsonItem
+// or jsonV2Item depending on the API version
+type jsonItemChange struct {
+	ID        int         `json:"id"`
+	Kind      string      `json:"kind"`
+	ActorID   *int        `json:"actor_id"`
+	RequestID string      `json:"request_id"`
+	At        time.Time   `json:"at"`
+	Before    interface{} `json:"before"`
+	After     interface{} `json:"after"`
+}
+
+// jsonHistory renders the history of an item, with its items read by readItem
+func jsonHistory(history itemHistory, readItem func(app.Item) interface{}) jsonItemHistory {
+	res := jsonItemHistory{
+		ItemID:  history.ItemID,
+		Changes: make([]jsonItemChange, 0, len(history.Changes)),
+	}
+	for _, change := range history.Changes {
+		jc := jsonItemChange{
+			ID:        change.ID,
+			Kind:      string(change.Kind),
+			RequestID: change.RequestID,
+			At:        change.At,
+		}
+		if change.ActorID != 0 {
+			actorID := change.ActorID
+			jc.ActorID = &actorID
+		}
+		if change.Before != nil {
+			jc.Before = readItem(*change.Before)
+		}
+		if change.After != nil {
+			jc.After = readItem(*change.After)
+		}
+		res.Changes = append(res.Changes, jc)
+	}
+	return res
+}
+
+// jsonImportError is what is wrong with a line of an imported file
+type jsonImportError struct {
+	Line    int      `json:"line"`
+	Fields  []string `json:"fields"`
+	Message string   `json:"error"`
+}
+
+func renderJSONItemError(w http.ResponseWriter, r *http.Request, err error) {
+	switch v := err.(type) {
+	case validationError:
+		renderJSONValidationError(w, v)
+	default:
+		switch err {
+		case app.ErrNotFound:
+			renderJSONNotFound(w)
+		case errForbidden:
+			renderJSONForbidden(w)
+		default:
+			renderJSONInternalError(w)
+		}
+	}
+}
+
+type jsonAccessToken struct {
+	ID        int         `json:"id"`
+	Name      string      `json:"name"`
+	Scopes    []app.Scope `json:"scopes"`
+	ExpiresAt *time.Time  `json:"expires_at"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+func (token *jsonAccessToken) read(t app.AccessToken) {
+	token.ID = t.ID
+	token.Name = t.Name
+	token.Scopes = t.Scopes
+	token.ExpiresAt = nil
+	if !t.ExpiresAt.IsZero() {
+		expiresAt := t.ExpiresAt
+		token.ExpiresAt = &expiresAt
+	}
+	token.CreatedAt = t.CreatedAt
+}
+
+func jsonAccessTokenRenderer() *accessTokenRenderer {
+	th := accessTokenRenderer{
+
+		renderIndexSuccess: func(w http.ResponseWriter, r *http.Request, tokens []app.AccessToken) {
+			res := make([]jsonAccessToken, 0, len(tokens))
+			for _, token := range tokens {
+				var jt jsonAccessToken
+				jt.read(token)
+				res = append(res, jt)
+			}
+			renderJSON(w, res, http.StatusOK)
+		},
+		renderIndexError: func(w http.ResponseWriter, r *http.Request, err error) {
+			renderJSONInternalError(w)
+		},
+		parseAccessToken: func(r *http.Request) (*app.AccessToken, error) {
+			var req struct {
+				Name      string      `json:"name"`
+				Scopes    []app.Scope `json:"scopes"`
+				ExpiresAt *time.Time  `json:"expires_at"`
+			}
+			dec := json.NewDecoder(r.Body)
+			err := dec.Decode(&req)
+			if err != nil {
+				return nil, validationError{
+					fields:  []string{"expires_at"},
+					message: "Expiry must be a RFC 3339 date",
+				}
+			}
+			token := app.AccessToken{
+				Name:   req.Name,
+				Scopes: req.Scopes,
+			}
+			if req.ExpiresAt != nil {
+				token.ExpiresAt = *req.ExpiresAt
+			}
+			return &token, nil
+		},
+		renderCreateSuccess: func(w http.ResponseWriter, r *http.Request, token *app.AccessToken, secret string) {
+			var res struct {
+				jsonAccessToken
+				Token string `json:"token"`
+			}
+			res.read(*token)
+			res.Token = secret
+			renderJSON(w, res, http.StatusCreated)
+		},
+		renderCreateError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch v := err.(type) {
+			case validationError:
+				renderJSONValidationError(w, v)
+			default:
+				renderJSONInternalError(w)
+			}
+		},
+		renderDeleteSuccess: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		},
+		renderDeleteError: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch err {
+			case app.ErrNotFound:
+				renderJSON(w, jsonError{
+					Message: "Access token not found",
+					Type:    "not_found",
+				}, http.StatusNotFound)
+			default:
+				renderJSONInternalError(w)
+			}
+		},
+	}
+	return &th
+}
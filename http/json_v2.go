@@ -0,0 +1,85 @@
+package http
+
+import (
+	"net/http"
+	app "useritem"
+)
+
+// Version 2 of the JSON API differs from version 1 in its items:
+// they tell who they belong to, and lists are wrapped in an object
+// so that they can grow without breaking clients
+
+type jsonV2Item struct {
+	ID     int        `json:"id"`
+	UserID int        `json:"user_id"`
+	Name   string     `json:"name"`
+	Price  int        `json:"price"`
+	Tags   []string   `json:"tags"`
+	Image  *jsonImage `json:"image"`
+}
+
+func (item *jsonV2Item) read(i app.Item) {
+	item.ID = i.ID
+	item.UserID = i.UserID
+	item.Name = i.Name
+	item.Price = i.Price
+	item.Tags = jsonTags(i.Tags)
+	item.Image = newJSONImage(i.Image)
+}
+
+func jsonV2ItemRenderer() *itemRenderer {
+	ih := jsonItemRenderer()
+	renderItem := func(status int) func(http.ResponseWriter, *http.Request, *app.Item) {
+		return func(w http.ResponseWriter, r *http.Request, item *app.Item) {
+			var res jsonV2Item
+			res.read(*item)
+			renderJSON(w, res, status)
+		}
+	}
+	ih.renderCreateSuccess = renderItem(http.StatusCreated)
+	ih.renderShow = renderItem(http.StatusOK)
+	ih.renderUpdateSuccess = renderItem(http.StatusOK)
+	ih.renderIndexSuccess = func(w http.ResponseWriter, r *http.Request, items []app.Item) error {
+		res := struct {
+			Items []jsonV2Item `json:"items"`
+		}{make([]jsonV2Item, 0, len(items))}
+		for _, item := range items {
+			var ji jsonV2Item
+			ji.read(item)
+			res.Items = append(res.Items, ji)
+		}
+		renderJSON(w, res, http.StatusOK)
+		return nil
+	}
+	ih.renderSearch = func(w http.ResponseWriter, r *http.Request, search itemSearch) {
+		res := jsonItemSearch{
+			Matches: make([]jsonItemMatch, 0, len(search.Matches)),
+			Page:    search.Page,
+			HasNext: search.HasNext,
+		}
+		for _, match := range search.Matches {
+			var item jsonV2Item
+			item.read(match.Item)
+			res.Matches = append(res.Matches, jsonItemMatch{
+				Item:       item,
+				Highlights: jsonHighlights(match.Highlights),
+			})
+		}
+		renderJSON(w, res, http.StatusOK)
+	}
+	ih.renderBatch = func(w http.ResponseWriter, r *http.Request, results []batchResult) {
+		renderJSON(w, jsonBatch(results, func(i app.Item) interface{} {
+			var item jsonV2Item
+			item.read(i)
+			return item
+		}), http.StatusOK)
+	}
+	ih.renderHistory = func(w http.ResponseWriter, r *http.Request, history itemHistory) {
+		renderJSON(w, jsonHistory(history, func(i app.Item) interface{} {
+			var item jsonV2Item
+			item.read(i)
+			return item
+		}), http.StatusOK)
+	}
+	return ih
+}
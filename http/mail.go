@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+	app "useritem"
+)
+
+const (
+	resetTokenTTL        = time.Hour
+	verificationTokenTTL = 24 * time.Hour
+)
+
+// linkMailer mails users links holding one-time tokens
+type linkMailer struct {
+	tokenRepo app.TokenRepo
+	mailer    app.Mailer
+	// baseURL is the public address links point to
+	baseURL string
+}
+
+func newLinkMailer(cfg Config) *linkMailer {
+	return &linkMailer{
+		tokenRepo: cfg.TokenRepo,
+		mailer:    cfg.Mailer,
+		baseURL:   cfg.BaseURL,
+	}
+}
+
+// sendPasswordReset mails a link to reset the password of an user
+func (m *linkMailer) sendPasswordReset(ctx context.Context, user *app.User) error {
+	// Create a reset token, only its hash is stored
+	secret, err := m.createToken(ctx, app.OneTimeToken{
+		Kind:      app.TokenPasswordReset,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(resetTokenTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := m.baseURL + "/password/reset?token=" + url.QueryEscape(secret)
+	return m.mailer.Send(app.Mail{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password of your account.\n"+
+			"If it was you, open the link below within %v to choose a new password:\n\n%s\n\n"+
+			"If it was not you, simply ignore this mail.\n",
+			user.Name, resetTokenTTL, link),
+	})
+}
+
+// sendVerification mails a link to verify the email of an user.
+// Links sent before are revoked
+func (m *linkMailer) sendVerification(ctx context.Context, user *app.User) error {
+	err := m.tokenRepo.DeleteByUser(ctx, app.TokenEmailVerification, user.ID)
+	if err != nil {
+		return err
+	}
+	secret, err := m.createToken(ctx, app.OneTimeToken{
+		Kind:      app.TokenEmailVerification,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(verificationTokenTTL),
+		Email:     user.Email,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := m.baseURL + "/email/verify?token=" + url.QueryEscape(secret)
+	return m.mailer.Send(app.Mail{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please open the link below within %v to confirm that this email address is yours:\n\n%s\n",
+			user.Name, verificationTokenTTL, link),
+	})
+}
+
+// createToken stores a new one-time token and returns its secret
+func (m *linkMailer) createToken(ctx context.Context, token app.OneTimeToken) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	token.Hash = hashSecret(secret)
+	err = m.tokenRepo.Create(ctx, &token)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
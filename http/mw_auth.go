@@ -1,11 +1,69 @@
-package http
-
-import (
-	"net/http"
-)
-
-// AuthMw is authentication middleware
-type AuthMw interface {
-	SetUser(next http.Handler) http.HandlerFunc
-	RequireUser(next http.Handler) http.HandlerFunc
-}
+package http
+
+import (
+	"net/http"
+	app "useritem"
+	"useritem/context"
+)
+
+// AuthMw is authentication middleware
+type AuthMw interface {
+	SetUser(next http.Handler) http.HandlerFunc
+	RequireUser(next http.Handler) http.HandlerFunc
+	RequireScope(scope app.Scope) Middleware
+	RequireRole(role app.Role) Middleware
+	RequirePermission(perm app.Permission) Middleware
+}
+
+// hasScope checks if a request may act within a scope.
+// Sessions hold every scope, access tokens only the ones granted to them
+func hasScope(r *http.Request, scope app.Scope) bool {
+	token, err := context.AccessToken(r.Context())
+	if err != nil {
+		logError(r, err)
+		return false
+	}
+	return token == nil || token.HasScope(scope)
+}
+
+// hasRole checks if the user of a request has a role
+func hasRole(r *http.Request, role app.Role) bool {
+	user := optionalUser(r)
+	return user != nil && user.HasRole(role)
+}
+
+// hasPermission checks if the user of a request has a permission
+func hasPermission(r *http.Request, perm app.Permission) bool {
+	user := optionalUser(r)
+	return user != nil && user.Can(perm)
+}
+
+// currentUser returns the user of a request behind RequireUser.
+// A missing user there is a bug: it panics,
+// and Recover answers with an internal error
+func currentUser(r *http.Request) *app.User {
+	user, err := context.User(r.Context())
+	if err != nil {
+		panic(err)
+	}
+	if user == nil {
+		panic("http: no user in context, is the route behind RequireUser?")
+	}
+	return user
+}
+
+// optionalUser returns the user of a request, or nil if there is none
+func optionalUser(r *http.Request) *app.User {
+	user, err := context.User(r.Context())
+	if err != nil {
+		logError(r, err)
+	}
+	return user
+}
+
+// setPrivate keeps the response to an authenticated request
+// out of every cache, since it belongs to one user
+func setPrivate(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Add("Vary", "Authorization, Cookie")
+}
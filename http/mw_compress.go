@@ -0,0 +1,174 @@
+package http
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressibleTypes are the content types worth compressing
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Compress compresses responses with brotli or gzip,
+// whichever the client prefers among the ones it accepts.
+// Strong ETags get the encoding appended, since the compressed
+// body is a different representation than the identity one
+func Compress(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Inner handlers compare ETags of the identity body
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			r.Header.Set("If-None-Match", strings.Replace(inm, "-"+encoding+`"`, `"`, -1))
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	}
+}
+
+// negotiateEncoding picks an encoding from an Accept-Encoding header.
+// It returns "" when the identity encoding should be used
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					q = v
+				}
+			}
+		}
+		if coding != "br" && coding != "gzip" {
+			continue
+		}
+		// Prefer brotli on ties, it compresses better
+		if q > bestQ || (q == bestQ && coding == "br") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressWriter compresses what is written to it, once it knows
+// the response is worth compressing. The status is held back until then,
+// since compressing changes the headers
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	started  bool
+	cw       io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.start(b)
+	}
+	if w.cw != nil {
+		return w.cw.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// start decides whether to compress, looking at the headers
+// and the first bytes of the body, then writes the status
+func (w *compressWriter) start(first []byte) {
+	w.started = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(first) > 0 {
+		// Sniff now, net/http would sniff the compressed bytes
+		h.Set("Content-Type", http.DetectContentType(first))
+	}
+	if w.status == http.StatusNotModified {
+		// Tell the client its compressed copy is still good
+		w.tagEncoding()
+	}
+	if len(first) > 0 && h.Get("Content-Encoding") == "" && isCompressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		w.tagEncoding()
+		switch w.encoding {
+		case "br":
+			w.cw = brotli.NewWriterLevel(w.ResponseWriter, brotli.DefaultCompression)
+		case "gzip":
+			w.cw = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// tagEncoding appends the encoding to a strong ETag
+func (w *compressWriter) tagEncoding() {
+	etag := w.Header().Get("ETag")
+	if strings.HasSuffix(etag, `"`) && !strings.HasPrefix(etag, "W/") {
+		w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+w.encoding+`"`)
+	}
+}
+
+// close flushes the compressed stream,
+// or the status if nothing was written
+func (w *compressWriter) close() {
+	if !w.started {
+		w.start(nil)
+	}
+	if w.cw != nil {
+		w.cw.Close()
+	}
+}
+
+// Flush sends what is compressed so far
+func (w *compressWriter) Flush() {
+	if !w.started {
+		w.start(nil)
+	}
+	if f, ok := w.cw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func isCompressible(contentType string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
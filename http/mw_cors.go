@@ -0,0 +1,184 @@
+package http
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSConfig tells which other origins may call the JSON API from a browser.
+// CORS is off when no origin is allowed
+type CORSConfig struct {
+	// AllowedOrigins are origins like https://app.example.com.
+	// They may hold wildcards, like https://*.example.com, and "*" allows any origin
+	AllowedOrigins []string
+	// AllowedMethods defaults to every method a route is served for
+	AllowedMethods []string
+	// AllowedHeaders are the request headers scripts may set,
+	// defaults to Authorization and Content-Type
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies along
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+var defaultCORSHeaders = []string{"Authorization", "Content-Type"}
+
+// cors implements CORSConfig
+type cors struct {
+	CORSConfig
+}
+
+func newCORS(cfg CORSConfig) *cors {
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = defaultCORSHeaders
+	}
+	return &cors{cfg}
+}
+
+// allowOrigin checks if an origin is allowed
+func (c *cors) allowOrigin(origin string) bool {
+	for _, pattern := range c.AllowedOrigins {
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(origin)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowMethod checks if a method may be sent cross-origin
+func (c *cors) allowMethod(method string) bool {
+	return len(c.AllowedMethods) == 0 || containsFold(c.AllowedMethods, method)
+}
+
+// setOrigin sets the headers every CORS response needs.
+// return false if the origin of the request is not allowed
+func (c *cors) setOrigin(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" || !c.allowOrigin(origin) {
+		return false
+	}
+	if containsFold(c.AllowedOrigins, "*") && !c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		// Browsers refuse "*" along with credentials
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// Middleware adds CORS headers to actual requests
+func (c *cors) Middleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			// Preflight, answered by the preflight routes
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c.setOrigin(w, r) && len(c.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// apiMiddleware adds CORS headers to the requests of the API.
+// Web pages are for their own origin only
+func (c *cors) apiMiddleware(next http.Handler) http.HandlerFunc {
+	api := c.Middleware(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rendererOf(r).web {
+			next.ServeHTTP(w, r)
+			return
+		}
+		api(w, r)
+	}
+}
+
+// preflight answers the OPTIONS requests browsers send before
+// a cross-origin request, for a route served for methods
+func (c *cors) preflight(methods []string) http.HandlerFunc {
+	allow := strings.Join(append(methods[:len(methods):len(methods)], http.MethodOptions), ", ")
+	var allowed []string
+	for _, method := range methods {
+		if c.allowMethod(method) {
+			allowed = append(allowed, method)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Allow", allow)
+		if !c.setOrigin(w, r) || !containsFold(allowed, r.Header.Get("Access-Control-Request-Method")) {
+			// Not allowed. Without CORS headers the browser gives up
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			header = strings.TrimSpace(header)
+			if header != "" && !containsFold(c.AllowedHeaders, header) {
+				w.Header().Del("Access-Control-Allow-Origin")
+				w.Header().Del("Access-Control-Allow-Credentials")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// preflightRoutes registers an OPTIONS route for every path of the API,
+// answering preflights with the methods the path is routed for.
+// They are shared by every renderer: preflights do not ask for JSON,
+// even for the negotiated routes outside /api.
+// It must be called once every other route is registered
+func (s *Server) preflightRoutes(c *cors) {
+	var paths []string
+	methods := make(map[string][]string)
+	s.walkAPI(func(route *mux.Route) {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return
+		}
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			return
+		}
+		if _, ok := methods[tpl]; !ok {
+			paths = append(paths, tpl)
+		}
+		methods[tpl] = append(methods[tpl], routeMethods...)
+	})
+	for _, tpl := range paths {
+		s.router.Handle(tpl, c.preflight(methods[tpl])).Methods(http.MethodOptions)
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
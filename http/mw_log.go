@@ -0,0 +1,104 @@
+package http
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+	"useritem/context"
+
+	"github.com/gorilla/mux"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID is what an incoming request id must look like to be kept
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID gives every request an id, reusing the X-Request-ID header
+// when the client sent a sane one. The id is sent back in the response
+// and a logger carrying it is stored in the request context
+func RequestID(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			var err error
+			id, err = newRequestID()
+			if err != nil {
+				slog.Error("failed to generate request id", "err", err)
+			}
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithRequestID(r.Context(), id)
+		ctx = context.WithLogger(ctx, slog.Default().With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// AccessLog writes one log line per request once it is served.
+// It must run after RequestID to log with the request's fields
+func AccessLog(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		context.Logger(r.Context()).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.status,
+			"bytes", rw.bytes,
+			"duration", time.Since(start),
+		)
+	}
+}
+
+// matchedRoute records the matched route template in the request context
+// and adds it to the request logger.
+// It is used as a router middleware since the route
+// is only known once the router matched it
+func matchedRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				context.SetRoute(r.Context(), tpl)
+				context.AddLogFields(r.Context(), "route", tpl)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logError logs an unexpected error with the request's fields
+func logError(r *http.Request, err error) {
+	context.Logger(r.Context()).Error("request failed", "err", err)
+}
+
+// responseWriter records the status and size of a response
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"useritem/context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// unmatchedRoute labels requests no route matched,
+// so that random paths do not blow up the number of series
+const unmatchedRoute = "none"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "useritem_http_requests_total",
+		Help: "HTTP requests served, by route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "useritem_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests, by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// Metrics counts and times requests, labeled by mux route template
+// and status. Routers must record the route with matchedRoute
+func Metrics(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(context.WithRoute(r.Context()))
+		next.ServeHTTP(rw, r)
+
+		route := context.Route(r.Context())
+		switch {
+		case route == "":
+			route = unmatchedRoute
+		default:
+			// JSON routes are matched after the prefix is stripped,
+			// keep them apart from the HTML ones and between versions
+			route = apiMount(r.URL.Path) + route
+		}
+		status := strconv.Itoa(rw.status)
+		httpRequests.WithLabelValues(r.Method, route, status).Inc()
+		httpDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// apiMount returns where the JSON API version serving a path is mounted,
+// or "" if the path is not under /api
+func apiMount(path string) string {
+	for _, version := range APIVersions {
+		if strings.HasPrefix(path, version.prefix()+"/") {
+			return version.prefix()
+		}
+	}
+	if strings.HasPrefix(path, apiPrefix+"/") {
+		return apiPrefix
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"net/http"
+	"runtime/debug"
+	"useritem/context"
+)
+
+// Recover turns a panic in a handler into an internal error
+// answered with render, instead of a dropped connection.
+// The panic is logged with its stack and the request's fields
+func Recover(render func(http.ResponseWriter, *http.Request)) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					// Deliberate abort, let net/http handle it
+					panic(v)
+				}
+				context.Logger(r.Context()).Error("panic serving request",
+					"panic", v,
+					"stack", string(debug.Stack()),
+				)
+				if rw.wroteHeader {
+					// Too late to answer an error
+					return
+				}
+				render(rw, r)
+			}()
+			next.ServeHTTP(rw, r)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package http
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	app "useritem"
+
+	"github.com/gorilla/mux"
+)
+
+// Renderer parses the requests and renders the responses of every handler
+// in a media type. Routes are the same whatever the media type, each request
+// is given a renderer and handlers call it through rendererOf
+type Renderer struct {
+	// auth authenticates requests the way clients of the media type do
+	auth         AuthMw
+	users        *userRenderer
+	items        *itemRenderer
+	accessTokens *accessTokenRenderer
+	admin        *adminRenderer
+	// internalError answers requests whose handler panicked
+	internalError func(http.ResponseWriter, *http.Request)
+	// web renderers serve pages and forms, the others the routes of the API
+	web bool
+	// spec is the OpenAPI document of the routes of the API, if any
+	spec []byte
+}
+
+type rendererKey struct{}
+
+// renderWith makes a renderer answer requests
+func renderWith(rd *Renderer) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rendererKey{}, rd)))
+		}
+	}
+}
+
+// rendererOf returns the renderer answering a request
+func rendererOf(r *http.Request) *Renderer {
+	return r.Context().Value(rendererKey{}).(*Renderer)
+}
+
+// rendersWeb matches the requests answered by web renderers,
+// or by the other ones when web is false
+func rendersWeb(web bool) mux.MatcherFunc {
+	return func(r *http.Request, match *mux.RouteMatch) bool {
+		return rendererOf(r).web == web
+	}
+}
+
+// rendererAuthMw authenticates requests with the AuthMw of their renderer:
+// sessions cookies for web pages, Authorization headers for the API
+type rendererAuthMw struct{}
+
+func (rendererAuthMw) SetUser(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rendererOf(r).auth.SetUser(next)(w, r)
+	}
+}
+
+func (rendererAuthMw) RequireUser(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rendererOf(r).auth.RequireUser(next)(w, r)
+	}
+}
+
+func (rendererAuthMw) RequireScope(scope app.Scope) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rendererOf(r).auth.RequireScope(scope)(next)(w, r)
+		}
+	}
+}
+
+func (rendererAuthMw) RequireRole(role app.Role) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rendererOf(r).auth.RequireRole(role)(next)(w, r)
+		}
+	}
+}
+
+func (rendererAuthMw) RequirePermission(perm app.Permission) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rendererOf(r).auth.RequirePermission(perm)(next)(w, r)
+		}
+	}
+}
+
+// Format is a media type the server can answer with, and its renderer
+type Format struct {
+	// MediaType is what clients ask for, like application/json
+	MediaType string
+	Renderer  *Renderer
+}
+
+// negotiator gives every request the renderer of the format
+// the client wants. The first format is the default one
+type negotiator struct {
+	formats []Format
+}
+
+// Middleware picks the format of requests,
+// answering 406 to clients accepting none
+func (n *negotiator) Middleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept, Content-Type")
+		format, ok := n.pick(r)
+		if !ok {
+			http.Error(w, "None of the requested media types can be served. Try one of: "+n.mediaTypes(),
+				http.StatusNotAcceptable)
+			return
+		}
+		renderWith(format.Renderer)(next)(w, r)
+	}
+}
+
+// pick chooses a format for a request: the one the Accept header names,
+// else the one of the body, else the default one.
+// return false if the client accepts none of the formats
+func (n *negotiator) pick(r *http.Request) (Format, bool) {
+	best := 0
+	accept := r.Header.Get("Accept")
+	if accept != "" {
+		bestQ, named := 0.0, false
+		best = -1
+		for i, format := range n.formats {
+			q, explicit := acceptQuality(accept, format.MediaType)
+			if q > bestQ {
+				best, bestQ, named = i, q, explicit
+			}
+		}
+		if best < 0 {
+			return Format{}, false
+		}
+		if named {
+			return n.formats[best], true
+		}
+		// Only wildcards matched, the client does not mind
+	}
+	if ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		for _, format := range n.formats {
+			if format.MediaType != ct {
+				continue
+			}
+			if accept != "" {
+				if q, _ := acceptQuality(accept, ct); q == 0 {
+					// The client refuses the format it sent
+					return Format{}, false
+				}
+			}
+			return format, true
+		}
+	}
+	return n.formats[best], true
+}
+
+func (n *negotiator) mediaTypes() string {
+	types := make([]string, 0, len(n.formats))
+	for _, format := range n.formats {
+		types = append(types, format.MediaType)
+	}
+	return strings.Join(types, ", ")
+}
+
+// acceptQuality returns the quality an Accept header gives a media type,
+// taken from the most specific range matching it.
+// explicit tells if the media type or its type was named, not just */*
+func acceptQuality(accept, mediaType string) (q float64, explicit bool) {
+	typ := strings.SplitN(mediaType, "/", 2)[0]
+	specificity := -1
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		rng := strings.ToLower(strings.TrimSpace(fields[0]))
+		s := -1
+		switch rng {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+	}
+	return q, specificity > 0
+}
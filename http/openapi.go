@@ -0,0 +1,109 @@
+package http
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPISpecV1 and openAPISpecV2 describe the versions of the JSON API.
+// Keep them in sync with Server.routes, TestOpenAPI fails otherwise
+var (
+	//go:embed openapi_v1.json
+	openAPISpecV1 []byte
+	//go:embed openapi_v2.json
+	openAPISpecV2 []byte
+)
+
+// openAPIDocs browses the OpenAPI document next to it
+// without loading anything from elsewhere
+//
+//go:embed docs.html
+var openAPIDocs []byte
+
+// ServeOpenAPI serves the OpenAPI document of the API version
+// of the request's renderer
+func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	spec := rendererOf(r).spec
+	if spec == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// ServeDocs serves a page to browse the OpenAPI document of an API version
+func ServeDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(openAPIDocs)
+}
+
+// routeVar matches a mux route variable with its pattern, like {id:[0-9]+}
+var routeVar = regexp.MustCompile(`\{(\w+):[^}]*\}`)
+
+// checkOpenAPI returns an error listing the routes of the API
+// that an OpenAPI document does not describe,
+// and the operations it describes that have no route
+func (s *Server) checkOpenAPI(openAPISpec []byte) error {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	err := json.Unmarshal(openAPISpec, &spec)
+	if err != nil {
+		return fmt.Errorf("http: openapi.json is not valid: %v", err)
+	}
+
+	var problems []string
+	routed := make(map[string]bool)
+	s.walkAPI(func(route *mux.Route) {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return
+		}
+		path := routeVar.ReplaceAllString(tpl, "{$1}")
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				// CORS preflights are not part of the contract
+				continue
+			}
+			routed[method+" "+path] = true
+			if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+				problems = append(problems, "does not describe "+method+" "+path)
+			}
+		}
+	})
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			method = strings.ToUpper(method)
+			if !openAPIMethods[method] {
+				// Parameters and summaries shared by the operations of a path
+				continue
+			}
+			if !routed[method+" "+path] {
+				problems = append(problems, "describes "+method+" "+path+" which has no route")
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("http: openapi.json %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// openAPIMethods are the keys of an OpenAPI path item naming operations
+var openAPIMethods = map[string]bool{
+	http.MethodGet: true, http.MethodPut: true, http.MethodPost: true, http.MethodDelete: true,
+	http.MethodOptions: true, http.MethodHead: true, http.MethodPatch: true, http.MethodTrace: true,
+}
@@ -0,0 +1,19 @@
+package http
+
+import (
+	"testing"
+)
+
+// TestOpenAPI checks that the OpenAPI document of every API version
+// describes all the routes of the API, and only them
+func TestOpenAPI(t *testing.T) {
+	server := newServer(Config{CORS: CORSConfig{AllowedOrigins: []string{"https://example.com"}}})
+	for _, version := range APIVersions {
+		t.Run(string(version), func(t *testing.T) {
+			err := server.checkOpenAPI(lookupAPIVersion(version).spec)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -1,6 +1,33 @@
-package http
-
-import "net/http"
-
-// ListenAndServe is the same as http.ListenAndServe
-var ListenAndServe = http.ListenAndServe
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// ListenAndServe is the same as http.ListenAndServe
+var ListenAndServe = http.ListenAndServe
+
+// shutdownTimeout is how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// ListenAndServeContext is like ListenAndServe,
+// but gracefully shuts the server down once ctx is done.
+// It returns nil after a clean shutdown
+func ListenAndServeContext(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+	errc := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		errc <- server.Shutdown(shutdownCtx)
+	}()
+
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return <-errc
+}
@@ -1,76 +1,307 @@
-package http
-
-import (
-	"net/http"
-	app "useritem"
-
-	"github.com/gorilla/mux"
-)
-
-// NewServer returns a server that handles both HTML and JSON
-func NewServer(userRepo app.UserRepo, itemRepo app.ItemRepo) http.Handler {
-	html := HTMLServer(userRepo, itemRepo)
-	json := JSONServer(userRepo, itemRepo)
-	mux := http.NewServeMux()
-	mux.Handle("/", html)
-	mux.Handle("/api", http.StripPrefix("/api", json))
-	return mux
-}
-
-// HTMLServer returns new HTML server
-func HTMLServer(userRepo app.UserRepo, itemRepo app.ItemRepo) http.Handler {
-	server := Server{
-		authMw: &htmlAuthMw{
-			userRepo: userRepo,
-		},
-		userHandler: htmlUserHandler(userRepo),
-		itemHandler: htmlItemHandler(itemRepo),
-		router:      mux.NewRouter(),
-	}
-	server.routes(true)
-	return &server
-}
-
-// JSONServer returns new JSON server
-func JSONServer(userRepo app.UserRepo, itemRepo app.ItemRepo) http.Handler {
-	server := Server{
-		authMw: &jsonAuthMw{
-			userRepo: userRepo,
-		},
-		userHandler: jsonUserHandler(userRepo),
-		itemHandler: jsonItemHandler(itemRepo),
-		router:      mux.NewRouter(),
-	}
-	server.routes(false)
-	return &server
-}
-
-// Server represents an http server
-type Server struct {
-	authMw      AuthMw
-	userHandler *UserHandler
-	itemHandler *ItemHandler
-	router      *mux.Router
-}
-
-func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.router.ServeHTTP(w, r)
-}
-
-func (s *Server) routes(webMode bool) {
-	if webMode {
-		s.router.Handle("/", http.RedirectHandler("/signin", http.StatusFound))
-		s.router.HandleFunc("/signin", s.userHandler.ShowSignin).Methods("GET")
-	}
-
-	s.router.HandleFunc("/signin", s.userHandler.ProcessSignin).Methods("POST")
-	s.router.Handle("/items", ApplyFunc(s.itemHandler.Index,
-		s.authMw.SetUser, s.authMw.RequireUser)).Methods("GET")
-	s.router.Handle("/items", ApplyFunc(s.itemHandler.Create,
-		s.authMw.SetUser, s.authMw.RequireUser)).Methods("POST")
-
-	if webMode {
-		s.router.Handle("/items/new", ApplyFunc(s.itemHandler.New,
-			s.authMw.SetUser, s.authMw.RequireUser)).Methods("GET")
-	}
-}
+package http
+
+import (
+	"net/http"
+	"time"
+	app "useritem"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// apiPrefix is where NewServer mounts the JSON API
+const apiPrefix = "/api"
+
+// Config holds everything the servers depend on
+type Config struct {
+	UserRepo  app.UserRepo
+	ItemRepo  app.ItemRepo
+	TokenRepo app.TokenRepo
+	Mailer    app.Mailer
+	// BlobStore keeps the images of items
+	BlobStore app.BlobStore
+
+	RecoveryCodeRepo app.RecoveryCodeRepo
+	AccessTokenRepo  app.AccessTokenRepo
+
+	// BaseURL is the public address of the server,
+	// used to build links sent in mails
+	BaseURL string
+	// CORS lets browser apps on other origins call the JSON API
+	CORS CORSConfig
+
+	// Formats are served outside of /api along HTML and JSON,
+	// to clients asking for them
+	Formats []Format
+
+	// APIDeprecation tells since when JSON API versions are deprecated,
+	// for versions replaced by a newer one. It defaults to the release
+	// of the newer one, like DefaultAPIv1Deprecation
+	APIDeprecation map[APIVersion]time.Time
+	// APISunset tells when deprecated JSON API versions go away
+	APISunset map[APIVersion]time.Time
+
+	// Health answers the probes of an orchestrator
+	Health *HealthHandler
+
+	// TemplatesDir, when set, is where HTML templates are loaded from
+	// on each request instead of the embedded ones, to edit them live
+	TemplatesDir string
+
+	// MaxBatchSize is how many items batch requests of the JSON API
+	// can act on, defaultMaxBatchSize when zero
+	MaxBatchSize int
+
+	// RequireVerifiedEmail forbids users to create items
+	// until they have verified their email address
+	RequireVerifiedEmail bool
+}
+
+// NewServer returns a server that handles both HTML and JSON.
+// Every route is served once, in the format each request is given:
+// the version of the JSON API under /api, else the format clients ask for
+func NewServer(cfg Config) http.Handler {
+	html, err := htmlRenderer(cfg)
+	if err != nil {
+		// A template is broken, better not start than fail on some pages
+		panic(err)
+	}
+	server := newServer(cfg)
+
+	mux := http.NewServeMux()
+	var latest *Renderer
+	for _, version := range APIVersions {
+		v := lookupAPIVersion(version)
+		json := jsonRenderer(cfg, version)
+		mws := []Middleware{renderWith(json)}
+		if !v.deprecatedAt.IsZero() {
+			deprecatedAt, ok := cfg.APIDeprecation[version]
+			if !ok {
+				deprecatedAt = v.deprecatedAt
+			}
+			mws = append(mws, v.deprecate(deprecatedAt, cfg.APISunset[version]))
+		}
+		api := Apply(server, mws...)
+		mux.Handle(version.prefix()+"/", http.StripPrefix(version.prefix(), api))
+		if version == APIv1 {
+			// Clients from before versioning use /api
+			mux.Handle(apiPrefix+"/", http.StripPrefix(apiPrefix, api))
+		}
+		latest = json
+	}
+
+	// Everything else answers in the format clients ask for
+	formats := append([]Format{
+		{MediaType: "text/html", Renderer: html},
+		{MediaType: "application/json", Renderer: latest},
+	}, cfg.Formats...)
+	mux.Handle("/", Apply(server, (&negotiator{formats: formats}).Middleware))
+
+	// metrics scrapes and probes are kept out of the access log and of the metrics
+	root := http.NewServeMux()
+	root.Handle("/metrics", promhttp.Handler())
+	if cfg.Health != nil {
+		root.HandleFunc("/healthz", cfg.Health.Live)
+		root.HandleFunc("/readyz", cfg.Health.Ready)
+		root.HandleFunc("/version", cfg.Health.Version)
+	}
+	root.Handle("/", Apply(mux, RequestID, Metrics, Tracing, AccessLog, Compress))
+	return root
+}
+
+// newServer returns a server of every route, answering requests
+// with the renderer they are given
+func newServer(cfg Config) *Server {
+	linkMailer := newLinkMailer(cfg)
+	server := Server{
+		authMw: rendererAuthMw{},
+		userHandler: &UserHandler{
+			userRepo:         cfg.UserRepo,
+			tokenRepo:        cfg.TokenRepo,
+			recoveryCodeRepo: cfg.RecoveryCodeRepo,
+			linkMailer:       linkMailer,
+		},
+		itemHandler: &ItemHandler{
+			itemRepo:             cfg.ItemRepo,
+			blobStore:            cfg.BlobStore,
+			requireVerifiedEmail: cfg.RequireVerifiedEmail,
+			maxBatchSize:         maxBatchSize(cfg),
+		},
+		accessTokenHandler: &AccessTokenHandler{
+			accessTokenRepo: cfg.AccessTokenRepo,
+		},
+		adminHandler: &AdminHandler{
+			userRepo:        cfg.UserRepo,
+			itemRepo:        cfg.ItemRepo,
+			accessTokenRepo: cfg.AccessTokenRepo,
+			linkMailer:      linkMailer,
+		},
+		router: mux.NewRouter(),
+	}
+	server.routes()
+	mws := []Middleware{Recover(func(w http.ResponseWriter, r *http.Request) {
+		rendererOf(r).internalError(w, r)
+	})}
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		cors := newCORS(cfg.CORS)
+		server.preflightRoutes(cors)
+		mws = append([]Middleware{cors.apiMiddleware}, mws...)
+	}
+	server.handler = Apply(server.router, mws...)
+	return &server
+}
+
+// Server represents an http server
+type Server struct {
+	authMw             AuthMw
+	userHandler        *UserHandler
+	itemHandler        *ItemHandler
+	accessTokenHandler *AccessTokenHandler
+	adminHandler       *AdminHandler
+	router             *mux.Router
+	// web holds the routes of pages and forms, matched for web renderers,
+	// api the routes matched for the others
+	web, api *mux.Router
+	// webRoute is the route of web, in router
+	webRoute *mux.Route
+	// handler is the router wrapped in server-wide middlewares
+	handler http.Handler
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// routes registers every route on the router. Routes for any renderer
+// come first, then the pages and forms, then the routes of the API
+func (s *Server) routes() {
+	s.router.Use(matchedRoute)
+	s.webRoute = s.router.MatcherFunc(rendersWeb(true))
+	s.web = s.webRoute.Subrouter()
+	s.api = s.router.MatcherFunc(rendersWeb(false)).Subrouter()
+
+	account := s.authMw.RequireScope(app.ScopeAccount)
+	readItems := s.authMw.RequireScope(app.ScopeItemsRead)
+	writeItems := s.authMw.RequireScope(app.ScopeItemsWrite)
+
+	s.web.Handle("/", http.RedirectHandler("/signin", http.StatusFound))
+	s.web.HandleFunc("/signin", s.userHandler.ShowSignin).Methods("GET")
+	s.web.HandleFunc("/signin/2fa", s.userHandler.ShowTwoFactor).Methods("GET")
+	s.web.HandleFunc("/signup", s.userHandler.ShowSignup).Methods("GET")
+	s.web.HandleFunc("/password/forgot", s.userHandler.ShowForgotPassword).Methods("GET")
+	s.web.HandleFunc("/password/reset", s.userHandler.ShowResetPassword).Methods("GET")
+
+	s.router.HandleFunc("/signin", s.userHandler.ProcessSignin).Methods("POST")
+	s.router.HandleFunc("/signin/2fa", s.userHandler.ProcessTwoFactor).Methods("POST")
+	s.router.HandleFunc("/signup", s.userHandler.ProcessSignup).Methods("POST")
+	s.router.HandleFunc("/password/forgot", s.userHandler.ProcessForgotPassword).Methods("POST")
+	s.router.HandleFunc("/password/reset", s.userHandler.ProcessResetPassword).Methods("POST")
+	s.router.HandleFunc("/email/verify", s.userHandler.VerifyEmail).Methods("GET")
+
+	s.router.Handle("/account", ApplyFunc(s.userHandler.ShowAccount,
+		s.authMw.SetUser, s.authMw.RequireUser, account)).Methods("GET")
+	s.router.Handle("/email", ApplyFunc(s.userHandler.ProcessChangeEmail,
+		s.authMw.SetUser, s.authMw.RequireUser, account)).Methods("POST")
+	s.router.Handle("/email/verify/resend", ApplyFunc(s.userHandler.ResendVerification,
+		s.authMw.SetUser, s.authMw.RequireUser, account)).Methods("POST")
+	s.router.Handle("/2fa/setup", ApplyFunc(s.userHandler.ShowTwoFactorSetup,
+		s.authMw.SetUser, s.authMw.RequireUser, account)).Methods("GET")
+	s.router.Handle("/2fa/setup", ApplyFunc(s.userHandler.ProcessTwoFactorSetup,
+		s.authMw.SetUser, s.authMw.RequireUser, account)).Methods("POST")
+
+	s.router.Handle("/tokens", ApplyFunc(s.accessTokenHandler.Index,
+		s.authMw.SetUser, s.authMw.RequireUser, account)).Methods("GET")
+	s.router.Handle("/tokens", ApplyFunc(s.accessTokenHandler.Create,
+		s.authMw.SetUser, s.authMw.RequireUser, account)).Methods("POST")
+	s.web.Handle("/tokens/{id:[0-9]+}/delete", ApplyFunc(s.accessTokenHandler.Delete,
+		s.authMw.SetUser, s.authMw.RequireUser, account)).Methods("POST")
+	s.api.Handle("/tokens/{id:[0-9]+}", ApplyFunc(s.accessTokenHandler.Delete,
+		s.authMw.SetUser, s.authMw.RequireUser, account)).Methods("DELETE")
+
+	s.router.Handle("/items", ApplyFunc(s.itemHandler.Index,
+		s.authMw.SetUser, s.authMw.RequireUser, readItems, ETag)).Methods("GET")
+	s.router.Handle("/items/search", ApplyFunc(s.itemHandler.Search,
+		s.authMw.SetUser, s.authMw.RequireUser, readItems)).Methods("GET")
+	s.router.Handle("/items", ApplyFunc(s.itemHandler.Create,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("POST")
+	s.router.Handle("/items/export", ApplyFunc(s.itemHandler.Export,
+		s.authMw.SetUser, s.authMw.RequireUser, readItems)).Methods("GET")
+	s.router.Handle("/items/import", ApplyFunc(s.itemHandler.Import,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("POST")
+	s.router.Handle("/items/{id:[0-9]+}/image", ApplyFunc(s.itemHandler.Image,
+		s.authMw.SetUser, s.authMw.RequireUser, readItems)).Methods("GET")
+	s.router.Handle("/items/{id:[0-9]+}/image/thumbnail", ApplyFunc(s.itemHandler.Thumbnail,
+		s.authMw.SetUser, s.authMw.RequireUser, readItems)).Methods("GET")
+	s.router.Handle("/items/{id:[0-9]+}/history", ApplyFunc(s.itemHandler.History,
+		s.authMw.SetUser, s.authMw.RequireUser, readItems)).Methods("GET")
+
+	s.web.Handle("/items/new", ApplyFunc(s.itemHandler.New,
+		s.authMw.SetUser, s.authMw.RequireUser)).Methods("GET")
+	s.web.Handle("/items/import", ApplyFunc(s.itemHandler.ShowImport,
+		s.authMw.SetUser, s.authMw.RequireUser)).Methods("GET")
+	s.web.Handle("/items/{id:[0-9]+}/edit", ApplyFunc(s.itemHandler.Edit,
+		s.authMw.SetUser, s.authMw.RequireUser, ETag)).Methods("GET")
+	s.web.Handle("/items/{id:[0-9]+}", ApplyFunc(s.itemHandler.Update,
+		s.authMw.SetUser, s.authMw.RequireUser)).Methods("POST")
+	s.web.Handle("/items/{id:[0-9]+}/delete", ApplyFunc(s.itemHandler.Delete,
+		s.authMw.SetUser, s.authMw.RequireUser, checkCSRF)).Methods("POST")
+	s.web.Handle("/users/{id:[0-9]+}/role", ApplyFunc(s.userHandler.UpdateRole,
+		s.authMw.SetUser, s.authMw.RequireUser, s.authMw.RequirePermission(app.PermManageUsers), checkCSRF)).Methods("POST")
+	s.web.Handle("/language", ApplyFunc(s.userHandler.UpdateLanguage,
+		s.authMw.SetUser)).Methods("POST")
+	s.adminRoutes()
+
+	s.api.HandleFunc("/openapi.json", ServeOpenAPI).Methods("GET")
+	s.api.HandleFunc("/docs", ServeDocs).Methods("GET")
+	s.api.Handle("/items/batch", ApplyFunc(s.itemHandler.BatchCreate,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("POST")
+	s.api.Handle("/items/batch", ApplyFunc(s.itemHandler.BatchUpdate,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("PUT")
+	s.api.Handle("/items/batch", ApplyFunc(s.itemHandler.BatchDelete,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("DELETE")
+	s.api.Handle("/items/{id:[0-9]+}", ApplyFunc(s.itemHandler.Show,
+		s.authMw.SetUser, s.authMw.RequireUser, readItems, ETag)).Methods("GET")
+	s.api.Handle("/items/{id:[0-9]+}", ApplyFunc(s.itemHandler.Update,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("PUT")
+	s.api.Handle("/items/{id:[0-9]+}", ApplyFunc(s.itemHandler.Delete,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("DELETE")
+	s.api.Handle("/items/{id:[0-9]+}/image", ApplyFunc(s.itemHandler.UploadImage,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("PUT")
+	s.api.Handle("/items/{id:[0-9]+}/image", ApplyFunc(s.itemHandler.DeleteImage,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("DELETE")
+	s.api.Handle("/items/{id:[0-9]+}/tags/{tag}", ApplyFunc(s.itemHandler.AddTag,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("PUT")
+	s.api.Handle("/items/{id:[0-9]+}/tags/{tag}", ApplyFunc(s.itemHandler.RemoveTag,
+		s.authMw.SetUser, s.authMw.RequireUser, writeItems)).Methods("DELETE")
+	s.api.Handle("/tags", ApplyFunc(s.itemHandler.Tags,
+		s.authMw.SetUser, s.authMw.RequireUser, readItems)).Methods("GET")
+	s.api.Handle("/users/{id:[0-9]+}/role", ApplyFunc(s.userHandler.UpdateRole,
+		s.authMw.SetUser, s.authMw.RequireUser, account, s.authMw.RequirePermission(app.PermManageUsers))).Methods("PUT")
+}
+
+// walkAPI calls fn for every route served to the renderers of the API
+func (s *Server) walkAPI(fn func(route *mux.Route)) {
+	s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		for _, ancestor := range ancestors {
+			if ancestor == s.webRoute {
+				return nil
+			}
+		}
+		fn(route)
+		return nil
+	})
+}
+
+// adminRoutes mounts the admin console, for admins only.
+// Its forms must carry the CSRF token of the session
+func (s *Server) adminRoutes() {
+	admin := func(h http.HandlerFunc) http.Handler {
+		return ApplyFunc(h, s.authMw.SetUser, s.authMw.RequireUser, s.authMw.RequireRole(app.RoleAdmin), checkCSRF)
+	}
+	s.web.Handle("/admin", admin(s.adminHandler.Index)).Methods("GET")
+	s.web.Handle("/admin/users/{id:[0-9]+}", admin(s.adminHandler.ShowUser)).Methods("GET")
+	s.web.Handle("/admin/users/{id:[0-9]+}/password-reset", admin(s.adminHandler.ResetPassword)).Methods("POST")
+	s.web.Handle("/admin/users/{id:[0-9]+}/sessions/revoke", admin(s.adminHandler.RevokeSessions)).Methods("POST")
+	s.web.Handle("/admin/users/{id:[0-9]+}/disable", admin(s.adminHandler.Disable)).Methods("POST")
+	s.web.Handle("/admin/users/{id:[0-9]+}/enable", admin(s.adminHandler.Enable)).Methods("POST")
+}
@@ -0,0 +1,166 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+	app "useritem"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxItemTags is how many tags an item can have
+	maxItemTags = 10
+	// maxTagLength is how many characters a tag can have
+	maxTagLength = 32
+	// maxTagSuggestions is how many tags are suggested
+	// to complete what a user types
+	maxTagSuggestions = 20
+)
+
+// Tags lists the tags of the current user starting with ?q=,
+// to complete what they type
+func (h *ItemHandler) Tags(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	user := currentUser(r)
+	prefix := normalizeTag(r.URL.Query().Get("q"))
+	tags, err := h.itemRepo.Tags(r.Context(), user.ID, prefix, maxTagSuggestions)
+	if err != nil {
+		logError(r, err)
+		rd.renderTagsError(w, r, err)
+		return
+	}
+	rd.renderTags(w, r, tags)
+}
+
+// AddTag puts the tag in the URL on an item
+func (h *ItemHandler) AddTag(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	item, err := h.itemFromRequest(r)
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	tag := normalizeTag(mux.Vars(r)["tag"])
+	tags := addTag(item.Tags, tag)
+	err = validateTags(tags)
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+
+	err = h.itemRepo.AddTag(r.Context(), item.ID, tag)
+	if err != nil {
+		logError(r, err)
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	item.Tags = tags
+	rd.renderUpdateSuccess(w, r, item)
+}
+
+// RemoveTag takes the tag in the URL off an item
+func (h *ItemHandler) RemoveTag(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).items
+	item, err := h.itemFromRequest(r)
+	if err != nil {
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	tag := normalizeTag(mux.Vars(r)["tag"])
+	err = h.itemRepo.RemoveTag(r.Context(), item.ID, tag)
+	if err != nil {
+		if err != app.ErrNotFound {
+			logError(r, err)
+		}
+		rd.renderUpdateError(w, r, err)
+		return
+	}
+	item.Tags = removeTag(item.Tags, tag)
+	rd.renderUpdateSuccess(w, r, item)
+}
+
+// tagSuggestions returns the tags of an user, to suggest them in forms.
+// Forms work without, so errors are only logged
+func (h *ItemHandler) tagSuggestions(r *http.Request, userID int) []string {
+	tags, err := h.itemRepo.Tags(r.Context(), userID, "", maxTagSuggestions)
+	if err != nil {
+		logError(r, err)
+	}
+	return tags
+}
+
+// normalizeTag makes tags that differ only by case or surrounding spaces equal
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// normalizeTags normalizes tags, dropping empty ones and duplicates,
+// and sorts them
+func normalizeTags(tags []string) []string {
+	res := []string{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag != "" {
+			res = addTag(res, tag)
+		}
+	}
+	return res
+}
+
+// addTag adds a tag to sorted tags, if it is not there yet
+func addTag(tags []string, tag string) []string {
+	i := sort.SearchStrings(tags, tag)
+	if i < len(tags) && tags[i] == tag {
+		return tags
+	}
+	res := make([]string, 0, len(tags)+1)
+	res = append(res, tags[:i]...)
+	res = append(res, tag)
+	return append(res, tags[i:]...)
+}
+
+// removeTag removes a tag from sorted tags
+func removeTag(tags []string, tag string) []string {
+	res := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if t != tag {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func validateTags(tags []string) error {
+	if len(tags) > maxItemTags {
+		return validationError{
+			fields:  []string{"tags"},
+			message: "An item can have at most %d tags",
+			args:    []interface{}{maxItemTags},
+		}
+	}
+	for _, tag := range tags {
+		if tag == "" {
+			return validationError{
+				fields:  []string{"tags"},
+				message: "Tags cannot be empty",
+			}
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return validationError{
+				fields:  []string{"tags"},
+				message: "Tags must have at most %d characters",
+				args:    []interface{}{maxTagLength},
+			}
+		}
+		if strings.ContainsAny(tag, ",/") {
+			return validationError{
+				fields:  []string{"tags"},
+				message: "Tags cannot contain commas or slashes",
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package http
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"useritem/i18n"
+
+	"golang.org/x/text/message"
+)
+
+// embeddedTemplates holds the HTML pages: templates/layout.html wraps
+// every page of templates/pages, which can use templates/partials
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// templateFuncs returns the helpers every template can call.
+// They translate and format in the language of a printer,
+// csrf is the token forms of the session must send back
+func templateFuncs(p *message.Printer, csrf string) template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": func() string {
+			return csrf
+		},
+		// t translates a message, formatted with args
+		"t": func(key string, args ...interface{}) string {
+			return p.Sprintf(key, args...)
+		},
+		"price": func(price int) string {
+			return p.Sprintf("%d VND", price)
+		},
+		"date": func(t time.Time) string {
+			// The layout of dates is translated too
+			return t.Format(p.Sprintf("2006-01-02"))
+		},
+		"datetime": func(t time.Time) string {
+			return t.Format(p.Sprintf("2006-01-02 15:04"))
+		},
+		"pngDataURL": func(png []byte) template.URL {
+			return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		},
+	}
+}
+
+// languageChoice is a language users can switch the UI to
+type languageChoice struct {
+	Tag  string
+	Name string
+}
+
+var languageChoices = func() []languageChoice {
+	var choices []languageChoice
+	for _, tag := range i18n.Languages {
+		choices = append(choices, languageChoice{Tag: tag.String(), Name: i18n.Name(tag)})
+	}
+	return choices
+}()
+
+// view is what the layout is executed with
+type view struct {
+	// Lang is the tag of the language of the page
+	Lang      string
+	Languages []languageChoice
+	Flash     *flash
+	// Data is what the page is executed with
+	Data interface{}
+}
+
+// templates renders the HTML pages
+type templates struct {
+	fsys fs.FS
+	// reload parses a page again each time it is rendered,
+	// so it can be edited without restarting the server
+	reload bool
+	pages  map[string]*template.Template
+}
+
+// loadTemplates parses the embedded templates,
+// or the ones in dir which are then reloaded on each render
+func loadTemplates(dir string) (*templates, error) {
+	if dir != "" {
+		return newTemplates(os.DirFS(dir), true)
+	}
+	fsys, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	return newTemplates(fsys, false)
+}
+
+// newTemplates parses every page of fsys, so that
+// a broken template stops the server from starting
+func newTemplates(fsys fs.FS, reload bool) (*templates, error) {
+	names, err := fs.Glob(fsys, "pages/*.html")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("http: no page found in templates")
+	}
+	t := templates{
+		fsys:   fsys,
+		reload: reload,
+		pages:  make(map[string]*template.Template),
+	}
+	for _, name := range names {
+		page := strings.TrimSuffix(path.Base(name), ".html")
+		t.pages[page], err = t.parse(page)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// parse parses a page along the layout and the partials
+func (t *templates) parse(page string) (*template.Template, error) {
+	tpl, err := template.New(page).Funcs(templateFuncs(i18n.NewPrinter(i18n.Languages[0]), "")).
+		ParseFS(t.fsys, "layout.html", "partials/*.html", "pages/"+page+".html")
+	if err != nil {
+		return nil, fmt.Errorf("http: parse page %s: %w", page, err)
+	}
+	return tpl, nil
+}
+
+func (t *templates) lookup(page string) (*template.Template, error) {
+	if t.reload {
+		return t.parse(page)
+	}
+	tpl, ok := t.pages[page]
+	if !ok {
+		return nil, fmt.Errorf("http: no page %s in templates", page)
+	}
+	return tpl, nil
+}
+
+// render writes a page with a status code.
+// The page is executed before anything is written,
+// so a failing template shows the error page instead of half a page.
+// It is in the language of the user, with the flash message
+// of the request on top if any
+func (t *templates) render(w http.ResponseWriter, r *http.Request, status int, page string, data interface{}) {
+	lang := requestLanguage(r)
+	var buf bytes.Buffer
+	tpl, err := t.lookup(page)
+	if err == nil {
+		// Translate a clone, as executed templates cannot be cloned
+		tpl, err = tpl.Clone()
+	}
+	if err == nil {
+		err = tpl.Funcs(templateFuncs(i18n.NewPrinter(lang), csrfToken(r))).ExecuteTemplate(&buf, "layout", view{
+			Lang:      lang.String(),
+			Languages: languageChoices,
+			Flash:     popFlash(w, r),
+			Data:      data,
+		})
+	}
+	if err != nil {
+		logError(r, err)
+		renderHTMLInternalError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", lang.String())
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
@@ -0,0 +1,209 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	app "useritem"
+	"useritem/totp"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	totpIssuer         = "UserItem"
+	twoFactorTTL       = 5 * time.Minute
+	recoveryCodesCount = 10
+)
+
+var (
+	errInvalidCode      = errors.New("http: two-factor code is not valid")
+	errTwoFactorEnabled = errors.New("http: two-factor authentication is already enabled")
+)
+
+// twoFactorSetup is what an user needs to add an account to their authenticator app
+type twoFactorSetup struct {
+	Secret string
+	URL    string
+	// QRCode is a PNG image of URL
+	QRCode []byte
+}
+
+// startTwoFactor issues a short-lived challenge to an user
+// who passed the password check but must still give a second factor
+func (h *UserHandler) startTwoFactor(w http.ResponseWriter, r *http.Request, user *app.User) {
+	rd := rendererOf(r).users
+	challenge, err := newSecret()
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessSigninError(w, r, err)
+		return
+	}
+	err = h.tokenRepo.Create(r.Context(), &app.OneTimeToken{
+		Kind:      app.TokenTwoFactor,
+		UserID:    user.ID,
+		Hash:      hashSecret(challenge),
+		ExpiresAt: time.Now().Add(twoFactorTTL),
+	})
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessSigninError(w, r, err)
+		return
+	}
+	rd.renderTwoFactorChallenge(w, r, challenge)
+}
+
+// ShowTwoFactor return the second signin step page
+func (h *UserHandler) ShowTwoFactor(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	rd.renderTwoFactor(w, r)
+}
+
+// ProcessTwoFactor check the second factor of a signin,
+// either a code from an authenticator app or a recovery code.
+// A challenge can only be tried once
+func (h *UserHandler) ProcessTwoFactor(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	challenge, code := rd.parseChallengeAndCode(r)
+	token, err := h.tokenRepo.Consume(r.Context(), app.TokenTwoFactor, hashSecret(challenge), time.Now())
+	if err != nil {
+		switch err {
+		case app.ErrNotFound:
+			rd.renderProcessTwoFactorError(w, r, errInvalidToken)
+		default:
+			logError(r, err)
+			rd.renderProcessTwoFactorError(w, r, err)
+		}
+		return
+	}
+
+	user, err := h.userRepo.ByID(r.Context(), token.UserID)
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessTwoFactorError(w, r, err)
+		return
+	}
+	if user.Disabled {
+		rd.renderProcessTwoFactorError(w, r, errAccountDisabled)
+		return
+	}
+
+	if step, ok := totp.ValidateStep(user.TOTPSecret, code, time.Now()); ok {
+		// A code can only be used once
+		err = h.userRepo.UseTOTPStep(r.Context(), user.ID, step)
+	} else {
+		// Maybe it is a recovery code
+		err = h.recoveryCodeRepo.Use(r.Context(), user.ID, hashSecret(normalizeRecoveryCode(code)))
+	}
+	if err != nil {
+		switch err {
+		case app.ErrNotFound:
+			rd.renderProcessTwoFactorError(w, r, errInvalidCode)
+		default:
+			logError(r, err)
+			rd.renderProcessTwoFactorError(w, r, err)
+		}
+		return
+	}
+	h.signin(w, r, user)
+}
+
+// ShowTwoFactorSetup shows the TOTP secret of the current user as a QR code,
+// generating it on the first visit. The secret is only enabled
+// once the user confirms it with a valid code
+func (h *UserHandler) ShowTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	user := currentUser(r)
+	if user.TOTPEnabled {
+		rd.renderTwoFactorSetupError(w, r, errTwoFactorEnabled)
+		return
+	}
+
+	// Keep the pending secret, reloading the page
+	// must not break an authenticator app already set up with it
+	secret := user.TOTPSecret
+	var err error
+	if secret == "" {
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			logError(r, err)
+			rd.renderTwoFactorSetupError(w, r, err)
+			return
+		}
+		err = h.userRepo.UpdateTOTP(r.Context(), user.ID, secret, false)
+		if err != nil {
+			logError(r, err)
+			rd.renderTwoFactorSetupError(w, r, err)
+			return
+		}
+	}
+
+	setup := twoFactorSetup{
+		Secret: secret,
+		URL:    totp.URL(totpIssuer, user.Email, secret),
+	}
+	setup.QRCode, err = qrcode.Encode(setup.URL, qrcode.Medium, 256)
+	if err != nil {
+		logError(r, err)
+		rd.renderTwoFactorSetupError(w, r, err)
+		return
+	}
+	rd.renderTwoFactorSetup(w, r, setup)
+}
+
+// ProcessTwoFactorSetup enables two-factor authentication
+// for the current user after checking a code,
+// and hands out recovery codes
+func (h *UserHandler) ProcessTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	user := currentUser(r)
+	if user.TOTPEnabled {
+		rd.renderProcessTwoFactorSetupError(w, r, errTwoFactorEnabled)
+		return
+	}
+	code := rd.parseCode(r)
+	step, ok := totp.ValidateStep(user.TOTPSecret, code, time.Now())
+	if user.TOTPSecret == "" || !ok {
+		rd.renderProcessTwoFactorSetupError(w, r, errInvalidCode)
+		return
+	}
+	err := h.userRepo.UseTOTPStep(r.Context(), user.ID, step)
+	if err != nil {
+		switch err {
+		case app.ErrNotFound:
+			rd.renderProcessTwoFactorSetupError(w, r, errInvalidCode)
+		default:
+			logError(r, err)
+			rd.renderProcessTwoFactorSetupError(w, r, err)
+		}
+		return
+	}
+
+	// Generate recovery codes, only their hashes are stored
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			logError(r, err)
+			rd.renderProcessTwoFactorSetupError(w, r, err)
+			return
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashSecret(normalizeRecoveryCode(code)))
+	}
+	err = h.recoveryCodeRepo.Replace(r.Context(), user.ID, hashes)
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessTwoFactorSetupError(w, r, err)
+		return
+	}
+
+	err = h.userRepo.UpdateTOTP(r.Context(), user.ID, user.TOTPSecret, true)
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessTwoFactorSetupError(w, r, err)
+		return
+	}
+	rd.renderProcessTwoFactorSetupSuccess(w, r, codes)
+}
@@ -1,66 +1,491 @@
-package http
-
-import (
-	"errors"
-	"fmt"
-	"log"
-	"net/http"
-	"strconv"
-	app "useritem"
-)
-
-var (
-	errAuthFailed = errors.New("http: authentication failed")
-)
-
-// UserHandler handles an user session
-type UserHandler struct {
-	userRepo app.UserRepo
-
-	renderSignin func(http.ResponseWriter)
-
-	parseEmailAndPassword      func(*http.Request) (email, password string)
-	renderProcessSigninSuccess func(w http.ResponseWriter, r *http.Request, token int)
-	renderProcessSigninError   func(http.ResponseWriter, *http.Request, error)
-}
-
-// ShowSignin return signin page
-func (h *UserHandler) ShowSignin(w http.ResponseWriter, r *http.Request) {
-	h.renderSignin(w)
-}
-
-// ProcessSignin check signin credentials
-func (h *UserHandler) ProcessSignin(w http.ResponseWriter, r *http.Request) {
-	// Parse email & password
-	email, password := h.parseEmailAndPassword(r)
-	// Lookup the user by their email in the DB
-	user, err := h.userRepo.ByEmail(email)
-	if err != nil {
-		switch err {
-		case app.ErrNotFound:
-			// Email doesn't map to a user in our DB
-			h.renderProcessSigninError(w, r, errAuthFailed)
-		default:
-			log.Println(err)
-			h.renderProcessSigninError(w, r, err)
-		}
-		return
-	}
-
-	// Check password
-	if !user.CheckPassword(password) {
-		http.Redirect(w, r, "/signin", http.StatusNotFound)
-		return
-	}
-
-	// Create a fake session token
-	tokenStr := fmt.Sprintf("2019%d", user.ID)
-	token, _ := strconv.Atoi(tokenStr)
-	err = h.userRepo.UpdateToken(user.ID, token)
-	if err != nil {
-		log.Println(err)
-		h.renderProcessSigninError(w, r, err)
-		return
-	}
-	h.renderProcessSigninSuccess(w, r, token)
-}
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+	app "useritem"
+	"useritem/i18n"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/language"
+)
+
+const (
+	minPasswordLength = 8
+)
+
+var (
+	errAuthFailed       = errors.New("http: authentication failed")
+	errInvalidToken     = errors.New("http: token is invalid or expired")
+	errEmailTaken       = errors.New("http: email is already taken")
+	errAlreadyVerified  = errors.New("http: email is already verified")
+	errEmailNotVerified = errors.New("http: email is not verified")
+	errAccountDisabled  = errors.New("http: account is disabled")
+)
+
+// UserHandler handles an user session
+type UserHandler struct {
+	userRepo         app.UserRepo
+	tokenRepo        app.TokenRepo
+	recoveryCodeRepo app.RecoveryCodeRepo
+	linkMailer       *linkMailer
+}
+
+// userRenderer parses the requests and renders the responses of UserHandler
+// in the format of a Renderer
+type userRenderer struct {
+	renderSignin func(http.ResponseWriter, *http.Request)
+
+	parseEmailAndPassword      func(*http.Request) (email, password string)
+	renderProcessSigninSuccess func(w http.ResponseWriter, r *http.Request, token int)
+	renderProcessSigninError   func(http.ResponseWriter, *http.Request, error)
+
+	renderForgotPassword func(http.ResponseWriter, *http.Request)
+
+	parseEmail                         func(*http.Request) string
+	renderProcessForgotPasswordSuccess func(http.ResponseWriter, *http.Request)
+	renderProcessForgotPasswordError   func(http.ResponseWriter, *http.Request, error)
+
+	renderResetPassword func(w http.ResponseWriter, r *http.Request, token string)
+
+	parseTokenAndPassword             func(*http.Request) (token, password string)
+	renderProcessResetPasswordSuccess func(http.ResponseWriter, *http.Request)
+	renderProcessResetPasswordError   func(http.ResponseWriter, *http.Request, error)
+
+	renderSignup func(http.ResponseWriter, *http.Request)
+
+	parseSignup              func(*http.Request) (name, email, password string)
+	renderProcessSignupError func(http.ResponseWriter, *http.Request, error)
+
+	renderAccount func(http.ResponseWriter, *http.Request, *app.User)
+
+	renderProcessChangeEmailSuccess func(http.ResponseWriter, *http.Request)
+	renderProcessChangeEmailError   func(http.ResponseWriter, *http.Request, error)
+
+	renderVerifyEmailSuccess func(http.ResponseWriter, *http.Request)
+	renderVerifyEmailError   func(http.ResponseWriter, *http.Request, error)
+
+	renderResendVerificationSuccess func(http.ResponseWriter, *http.Request)
+	renderResendVerificationError   func(http.ResponseWriter, *http.Request, error)
+
+	renderTwoFactorChallenge func(w http.ResponseWriter, r *http.Request, challenge string)
+	renderTwoFactor          func(http.ResponseWriter, *http.Request)
+
+	parseChallengeAndCode       func(*http.Request) (challenge, code string)
+	renderProcessTwoFactorError func(http.ResponseWriter, *http.Request, error)
+
+	renderTwoFactorSetup      func(w http.ResponseWriter, r *http.Request, setup twoFactorSetup)
+	renderTwoFactorSetupError func(http.ResponseWriter, *http.Request, error)
+
+	parseCode                          func(*http.Request) string
+	renderProcessTwoFactorSetupSuccess func(w http.ResponseWriter, r *http.Request, recoveryCodes []string)
+	renderProcessTwoFactorSetupError   func(http.ResponseWriter, *http.Request, error)
+
+	parseRole               func(*http.Request) app.Role
+	renderUpdateRoleSuccess func(http.ResponseWriter, *http.Request, *app.User)
+	renderUpdateRoleError   func(http.ResponseWriter, *http.Request, error)
+
+	parseLanguage               func(*http.Request) string
+	renderUpdateLanguageSuccess func(w http.ResponseWriter, r *http.Request, lang language.Tag)
+	renderUpdateLanguageError   func(http.ResponseWriter, *http.Request, error)
+}
+
+// ShowSignin return signin page
+func (h *UserHandler) ShowSignin(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	rd.renderSignin(w, r)
+}
+
+// ProcessSignin check signin credentials
+func (h *UserHandler) ProcessSignin(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	// Parse email & password
+	email, password := rd.parseEmailAndPassword(r)
+	// Lookup the user by their email in the DB
+	user, err := h.userRepo.ByEmail(r.Context(), email)
+	if err != nil {
+		switch err {
+		case app.ErrNotFound:
+			// Email doesn't map to a user in our DB
+			rd.renderProcessSigninError(w, r, errAuthFailed)
+		default:
+			logError(r, err)
+			rd.renderProcessSigninError(w, r, err)
+		}
+		return
+	}
+
+	// Check password
+	if !user.CheckPassword(password) {
+		rd.renderProcessSigninError(w, r, errAuthFailed)
+		return
+	}
+	if user.Disabled {
+		rd.renderProcessSigninError(w, r, errAccountDisabled)
+		return
+	}
+
+	// Ask for a second factor if the user enabled it
+	if user.TOTPEnabled {
+		h.startTwoFactor(w, r, user)
+		return
+	}
+	h.signin(w, r, user)
+}
+
+// signin starts a new session for an user
+func (h *UserHandler) signin(w http.ResponseWriter, r *http.Request, user *app.User) {
+	rd := rendererOf(r).users
+	// Create a new session token
+	token, err := newSessionToken()
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessSigninError(w, r, err)
+		return
+	}
+	err = h.userRepo.UpdateToken(r.Context(), user.ID, token)
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessSigninError(w, r, err)
+		return
+	}
+	rd.renderProcessSigninSuccess(w, r, token)
+}
+
+// ShowForgotPassword return forgot password page
+func (h *UserHandler) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	rd.renderForgotPassword(w, r)
+}
+
+// ProcessForgotPassword mails a password reset link to an user
+func (h *UserHandler) ProcessForgotPassword(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	email := rd.parseEmail(r)
+	user, err := h.userRepo.ByEmail(r.Context(), email)
+	if err != nil {
+		switch err {
+		case app.ErrNotFound:
+			// Don't tell whether an email maps to a user
+			rd.renderProcessForgotPasswordSuccess(w, r)
+		default:
+			logError(r, err)
+			rd.renderProcessForgotPasswordError(w, r, err)
+		}
+		return
+	}
+
+	err = h.linkMailer.sendPasswordReset(r.Context(), user)
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessForgotPasswordError(w, r, err)
+		return
+	}
+	rd.renderProcessForgotPasswordSuccess(w, r)
+}
+
+// ShowResetPassword return reset password page
+func (h *UserHandler) ShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	rd.renderResetPassword(w, r, r.URL.Query().Get("token"))
+}
+
+// ProcessResetPassword sets a new password using a reset token
+// and signs the user out everywhere
+func (h *UserHandler) ProcessResetPassword(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	secret, password := rd.parseTokenAndPassword(r)
+	err := validatePassword(password)
+	if err != nil {
+		rd.renderProcessResetPasswordError(w, r, err)
+		return
+	}
+
+	// Use up the token
+	token, err := h.tokenRepo.Consume(r.Context(), app.TokenPasswordReset, hashSecret(secret), time.Now())
+	if err != nil {
+		switch err {
+		case app.ErrNotFound:
+			rd.renderProcessResetPasswordError(w, r, errInvalidToken)
+		default:
+			logError(r, err)
+			rd.renderProcessResetPasswordError(w, r, err)
+		}
+		return
+	}
+
+	err = h.userRepo.UpdatePassword(r.Context(), token.UserID, password)
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessResetPasswordError(w, r, err)
+		return
+	}
+
+	// Invalidate existing sessions and other reset links
+	session, err := newSessionToken()
+	if err == nil {
+		err = h.userRepo.UpdateToken(r.Context(), token.UserID, session)
+	}
+	if err == nil {
+		err = h.tokenRepo.DeleteByUser(r.Context(), app.TokenPasswordReset, token.UserID)
+	}
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessResetPasswordError(w, r, err)
+		return
+	}
+	rd.renderProcessResetPasswordSuccess(w, r)
+}
+
+// ShowSignup return signup page
+func (h *UserHandler) ShowSignup(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	rd.renderSignup(w, r)
+}
+
+// ProcessSignup creates a new user, signs them in
+// and mails them a link to verify their email
+func (h *UserHandler) ProcessSignup(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	name, email, password := rd.parseSignup(r)
+	err := validateSignup(name, email, password)
+	if err != nil {
+		rd.renderProcessSignupError(w, r, err)
+		return
+	}
+
+	user := app.User{
+		Name:  name,
+		Email: email,
+	}
+	err = h.userRepo.Create(r.Context(), &user, password)
+	if err != nil {
+		switch err {
+		case app.ErrConflict:
+			rd.renderProcessSignupError(w, r, errEmailTaken)
+		default:
+			logError(r, err)
+			rd.renderProcessSignupError(w, r, err)
+		}
+		return
+	}
+
+	// The account exists now, a lost mail can be resent later
+	err = h.linkMailer.sendVerification(r.Context(), &user)
+	if err != nil {
+		logError(r, err)
+	}
+
+	token, err := newSessionToken()
+	if err == nil {
+		err = h.userRepo.UpdateToken(r.Context(), user.ID, token)
+	}
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessSignupError(w, r, err)
+		return
+	}
+	rd.renderProcessSigninSuccess(w, r, token)
+}
+
+// ShowAccount return the account page of the current user
+func (h *UserHandler) ShowAccount(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	rd.renderAccount(w, r, currentUser(r))
+}
+
+// ProcessChangeEmail changes the email of the current user
+// and mails a link to verify the new address
+func (h *UserHandler) ProcessChangeEmail(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	user := currentUser(r)
+
+	// The current password is required to change the email
+	email, password := rd.parseEmailAndPassword(r)
+	if !user.CheckPassword(password) {
+		rd.renderProcessChangeEmailError(w, r, errAuthFailed)
+		return
+	}
+	err := validateEmail(email)
+	if err != nil {
+		rd.renderProcessChangeEmailError(w, r, err)
+		return
+	}
+
+	err = h.userRepo.UpdateEmail(r.Context(), user.ID, email)
+	if err != nil {
+		switch err {
+		case app.ErrConflict:
+			rd.renderProcessChangeEmailError(w, r, errEmailTaken)
+		default:
+			logError(r, err)
+			rd.renderProcessChangeEmailError(w, r, err)
+		}
+		return
+	}
+	user.Email = strings.ToLower(email)
+	user.EmailVerified = false
+
+	err = h.linkMailer.sendVerification(r.Context(), user)
+	if err != nil {
+		logError(r, err)
+		rd.renderProcessChangeEmailError(w, r, err)
+		return
+	}
+	rd.renderProcessChangeEmailSuccess(w, r)
+}
+
+// VerifyEmail marks an email as verified using a verification token
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	secret := r.URL.Query().Get("token")
+	token, err := h.tokenRepo.Consume(r.Context(), app.TokenEmailVerification, hashSecret(secret), time.Now())
+	if err != nil {
+		switch err {
+		case app.ErrNotFound:
+			rd.renderVerifyEmailError(w, r, errInvalidToken)
+		default:
+			logError(r, err)
+			rd.renderVerifyEmailError(w, r, err)
+		}
+		return
+	}
+
+	// The token is useless if the user changed their email since
+	err = h.userRepo.MarkEmailVerified(r.Context(), token.UserID, token.Email)
+	if err != nil {
+		switch err {
+		case app.ErrNotFound:
+			rd.renderVerifyEmailError(w, r, errInvalidToken)
+		default:
+			logError(r, err)
+			rd.renderVerifyEmailError(w, r, err)
+		}
+		return
+	}
+
+	err = h.tokenRepo.DeleteByUser(r.Context(), app.TokenEmailVerification, token.UserID)
+	if err != nil {
+		logError(r, err)
+	}
+	rd.renderVerifyEmailSuccess(w, r)
+}
+
+// ResendVerification mails a new verification link to the current user.
+// Links sent before stop working
+func (h *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	user := currentUser(r)
+	if user.EmailVerified {
+		rd.renderResendVerificationError(w, r, errAlreadyVerified)
+		return
+	}
+	err := h.linkMailer.sendVerification(r.Context(), user)
+	if err != nil {
+		logError(r, err)
+		rd.renderResendVerificationError(w, r, err)
+		return
+	}
+	rd.renderResendVerificationSuccess(w, r)
+}
+
+func validateSignup(name, email, password string) error {
+	if strings.TrimSpace(name) == "" {
+		return validationError{
+			fields:  []string{"name"},
+			message: "Name is required",
+		}
+	}
+	err := validateEmail(email)
+	if err != nil {
+		return err
+	}
+	return validatePassword(password)
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return validationError{
+			fields:  []string{"password"},
+			message: "Password must have at least %d characters",
+			args:    []interface{}{minPasswordLength},
+		}
+	}
+	return nil
+}
+
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return validationError{
+			fields:  []string{"email"},
+			message: "Email address is not valid",
+		}
+	}
+	return nil
+}
+
+// UpdateRole gives a role to the user whose id is in the URL
+func (h *UserHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rd.renderUpdateRoleError(w, r, app.ErrNotFound)
+		return
+	}
+	role := rd.parseRole(r)
+	if !app.ValidRole(role) {
+		rd.renderUpdateRoleError(w, r, validationError{
+			fields:  []string{"role"},
+			message: "Unknown role %s",
+			args:    []interface{}{role},
+		})
+		return
+	}
+
+	user, err := h.userRepo.ByID(r.Context(), id)
+	if err != nil {
+		if err != app.ErrNotFound {
+			logError(r, err)
+		}
+		rd.renderUpdateRoleError(w, r, err)
+		return
+	}
+	err = h.userRepo.UpdateRole(r.Context(), user.ID, role)
+	if err != nil {
+		logError(r, err)
+		rd.renderUpdateRoleError(w, r, err)
+		return
+	}
+	user.Role = role
+	rd.renderUpdateRoleSuccess(w, r, user)
+}
+
+// UpdateLanguage switches the language of the UI.
+// It becomes the preference of the user if signed in
+func (h *UserHandler) UpdateLanguage(w http.ResponseWriter, r *http.Request) {
+	rd := rendererOf(r).users
+	s := rd.parseLanguage(r)
+	lang, ok := i18n.Lookup(s)
+	if !ok {
+		rd.renderUpdateLanguageError(w, r, validationError{
+			fields:  []string{"language"},
+			message: "Unknown language %s",
+			args:    []interface{}{s},
+		})
+		return
+	}
+	if user := optionalUser(r); user != nil {
+		err := h.userRepo.UpdateLanguage(r.Context(), user.ID, lang.String())
+		if err != nil {
+			logError(r, err)
+			rd.renderUpdateLanguageError(w, r, err)
+			return
+		}
+	}
+	rd.renderUpdateLanguageSuccess(w, r, lang)
+}
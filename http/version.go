@@ -0,0 +1,83 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// APIVersion is a version of the JSON API, mounted at /api/<version>
+type APIVersion string
+
+// JSON API versions, oldest first
+const (
+	APIv1 APIVersion = "v1"
+	APIv2 APIVersion = "v2"
+)
+
+// APIVersions are the versions NewServer mounts, oldest first
+var APIVersions = []APIVersion{APIv1, APIv2}
+
+// DefaultAPIv1Deprecation is the date APIv1 is announced as deprecated since,
+// the release of APIv2, unless Config.APIDeprecation tells otherwise
+const DefaultAPIv1Deprecation = "2026-10-19"
+
+// apiVersion holds what tells a version of the JSON API apart.
+// Versions share the handlers, only their renderers differ
+type apiVersion struct {
+	itemRenderer func() *itemRenderer
+	spec         []byte
+	// deprecatedAt is by default when a newer version replaced this one,
+	// zero for the current version
+	deprecatedAt time.Time
+	successor    APIVersion
+}
+
+func lookupAPIVersion(version APIVersion) apiVersion {
+	switch version {
+	case APIv1:
+		return apiVersion{
+			itemRenderer: jsonItemRenderer,
+			spec:         openAPISpecV1,
+			deprecatedAt: mustParseDate(DefaultAPIv1Deprecation),
+			successor:    APIv2,
+		}
+	case APIv2:
+		return apiVersion{
+			itemRenderer: jsonV2ItemRenderer,
+			spec:         openAPISpecV2,
+		}
+	default:
+		panic(fmt.Sprintf("http: unknown API version %q", version))
+	}
+}
+
+// mustParseDate parses a date like 2026-10-19, it panics if it is not one
+func mustParseDate(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// prefix is where a version is mounted
+func (version APIVersion) prefix() string {
+	return apiPrefix + "/" + string(version)
+}
+
+// deprecate marks the responses of a deprecated version with
+// a Deprecation header (RFC 9745) telling since when, a Sunset header (RFC 8594)
+// if it is known when the version goes away, and a link to its successor
+func (v apiVersion) deprecate(deprecatedAt, sunset time.Time) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecatedAt.Unix()))
+			if !sunset.IsZero() {
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, v.successor.prefix()))
+			next.ServeHTTP(w, r)
+		}
+	}
+}
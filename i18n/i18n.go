@@ -0,0 +1,99 @@
+// Package i18n translates the UI.
+// Messages are identified by their English text, which is shown
+// as is when a translation is missing. Translations are kept in
+// a JSON catalog per language, named after its tag, like vi.json
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+// Languages are the languages the UI is available in.
+// The first one is the default
+var Languages = []language.Tag{language.English, language.Vietnamese}
+
+// names are the names of Languages, in themselves
+var names = map[language.Tag]string{
+	language.English:    "English",
+	language.Vietnamese: "Tiếng Việt",
+}
+
+//go:embed *.json
+var catalogs embed.FS
+
+var (
+	matcher = language.NewMatcher(Languages)
+	cat     = mustLoad()
+)
+
+// mustLoad builds the catalog of every language but the default,
+// whose messages are their own translation
+func mustLoad() catalog.Catalog {
+	b := catalog.NewBuilder(catalog.Fallback(Languages[0]))
+	for _, tag := range Languages[1:] {
+		data, err := catalogs.ReadFile(tag.String() + ".json")
+		if err != nil {
+			panic(fmt.Sprintf("i18n: no catalog for %s: %v", tag, err))
+		}
+		var messages map[string]string
+		err = json.Unmarshal(data, &messages)
+		if err != nil {
+			panic(fmt.Sprintf("i18n: catalog of %s: %v", tag, err))
+		}
+		for key, msg := range messages {
+			err = b.SetString(tag, key, msg)
+			if err != nil {
+				panic(fmt.Sprintf("i18n: catalog of %s: %q: %v", tag, key, err))
+			}
+		}
+	}
+	return b
+}
+
+// Match returns the language that suits best a list of preferences,
+// most important first. Each is a tag or an Accept-Language header,
+// empty and invalid ones are skipped.
+// If none is available, Match returns the default language
+func Match(prefs ...string) language.Tag {
+	var tags []language.Tag
+	for _, pref := range prefs {
+		t, _, err := language.ParseAcceptLanguage(pref)
+		if err != nil {
+			continue
+		}
+		tags = append(tags, t...)
+	}
+	_, i, _ := matcher.Match(tags...)
+	return Languages[i]
+}
+
+// Lookup returns the available language a tag like "vi" names
+func Lookup(s string) (language.Tag, bool) {
+	tag, err := language.Parse(s)
+	if err != nil {
+		return language.Und, false
+	}
+	for _, l := range Languages {
+		if l == tag {
+			return l, true
+		}
+	}
+	return language.Und, false
+}
+
+// Name returns the name of an available language, in itself
+func Name(tag language.Tag) string {
+	return names[tag]
+}
+
+// NewPrinter returns a printer that translates messages into a language,
+// and formats numbers the way it does
+func NewPrinter(tag language.Tag) *message.Printer {
+	return message.NewPrinter(tag, message.Catalog(cat))
+}
@@ -0,0 +1,13 @@
+package app
+
+// Mail is a message sent to an user's email address
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is an interface for delivering mails to users
+type Mailer interface {
+	Send(mail Mail) error
+}
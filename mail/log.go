@@ -0,0 +1,28 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	app "useritem"
+)
+
+// LogMailer is an implementation of app.Mailer for development.
+// Instead of delivering mails, it writes them to W,
+// which is usually os.Stderr or a file
+type LogMailer struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+// Send writes a mail to the underlying writer
+// return an error
+func (m *LogMailer) Send(mail app.Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.W, "--- mail sent at %s\nTo: %s\nSubject: %s\n\n%s\n---\n",
+		time.Now().Format(time.RFC3339), mail.To, mail.Subject, mail.Body)
+	return err
+}
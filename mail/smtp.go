@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+	app "useritem"
+)
+
+// SMTPMailer is an SMTP specific implementation of app.Mailer
+type SMTPMailer struct {
+	// Addr is the address of the SMTP server, e.g. "smtp.example.com:587"
+	Addr string
+	// Auth is optional, leave it nil for servers without authentication
+	Auth smtp.Auth
+	// From is the sender address
+	From string
+}
+
+// Send delivers a mail through the SMTP server
+// return an error
+func (m *SMTPMailer) Send(mail app.Mail) error {
+	to := strings.TrimSpace(mail.To)
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("mail: invalid recipient %q", mail.To)
+	}
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, message(m.From, mail))
+}
+
+// message formats a mail according to RFC 5322
+func message(from string, mail app.Mail) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprint(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprint(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprint(&buf, "\r\n")
+	buf.WriteString(strings.Replace(mail.Body, "\n", "\r\n", -1))
+	return buf.Bytes()
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+	app "useritem"
+)
+
+// BlobStore measures calls to another app.BlobStore
+type BlobStore struct {
+	Next app.BlobStore
+}
+
+// Put measures app.BlobStore.Put
+func (s *BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (err error) {
+	defer observe("blob", "Put", time.Now(), &err)
+	return s.Next.Put(ctx, key, r, size, contentType)
+}
+
+// Get measures app.BlobStore.Get, until the blob is opened
+func (s *BlobStore) Get(ctx context.Context, key string) (_ io.ReadCloser, err error) {
+	defer observe("blob", "Get", time.Now(), &err)
+	return s.Next.Get(ctx, key)
+}
+
+// Delete measures app.BlobStore.Delete
+func (s *BlobStore) Delete(ctx context.Context, key string) (err error) {
+	defer observe("blob", "Delete", time.Now(), &err)
+	return s.Next.Delete(ctx, key)
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"context"
+	"time"
+	app "useritem"
+)
+
+// ItemRepo measures calls to another app.ItemRepo
+type ItemRepo struct {
+	Next app.ItemRepo
+}
+
+// ByID measures app.ItemRepo.ByID
+func (repo *ItemRepo) ByID(ctx context.Context, id int) (_ *app.Item, err error) {
+	defer observe("item", "ByID", time.Now(), &err)
+	return repo.Next.ByID(ctx, id)
+}
+
+// ByUser measures app.ItemRepo.ByUser
+func (repo *ItemRepo) ByUser(ctx context.Context, userID int) (_ []app.Item, err error) {
+	defer observe("item", "ByUser", time.Now(), &err)
+	return repo.Next.ByUser(ctx, userID)
+}
+
+// ByUserAfter measures app.ItemRepo.ByUserAfter
+func (repo *ItemRepo) ByUserAfter(ctx context.Context, userID, afterID, limit int) (_ []app.Item, err error) {
+	defer observe("item", "ByUserAfter", time.Now(), &err)
+	return repo.Next.ByUserAfter(ctx, userID, afterID, limit)
+}
+
+// ByTag measures app.ItemRepo.ByTag
+func (repo *ItemRepo) ByTag(ctx context.Context, userID int, tag string) (_ []app.Item, err error) {
+	defer observe("item", "ByTag", time.Now(), &err)
+	return repo.Next.ByTag(ctx, userID, tag)
+}
+
+// Create measures app.ItemRepo.Create
+func (repo *ItemRepo) Create(ctx context.Context, item *app.Item) (err error) {
+	defer observe("item", "Create", time.Now(), &err)
+	return repo.Next.Create(ctx, item)
+}
+
+// CreateAll measures app.ItemRepo.CreateAll
+func (repo *ItemRepo) CreateAll(ctx context.Context, items []app.Item) (err error) {
+	defer observe("item", "CreateAll", time.Now(), &err)
+	return repo.Next.CreateAll(ctx, items)
+}
+
+// Update measures app.ItemRepo.Update
+func (repo *ItemRepo) Update(ctx context.Context, item *app.Item) (err error) {
+	defer observe("item", "Update", time.Now(), &err)
+	return repo.Next.Update(ctx, item)
+}
+
+// UpdateAll measures app.ItemRepo.UpdateAll
+func (repo *ItemRepo) UpdateAll(ctx context.Context, items []app.Item) (err error) {
+	defer observe("item", "UpdateAll", time.Now(), &err)
+	return repo.Next.UpdateAll(ctx, items)
+}
+
+// Delete measures app.ItemRepo.Delete
+func (repo *ItemRepo) Delete(ctx context.Context, id int) (err error) {
+	defer observe("item", "Delete", time.Now(), &err)
+	return repo.Next.Delete(ctx, id)
+}
+
+// DeleteAll measures app.ItemRepo.DeleteAll
+func (repo *ItemRepo) DeleteAll(ctx context.Context, ids []int) (err error) {
+	defer observe("item", "DeleteAll", time.Now(), &err)
+	return repo.Next.DeleteAll(ctx, ids)
+}
+
+// AddTag measures app.ItemRepo.AddTag
+func (repo *ItemRepo) AddTag(ctx context.Context, itemID int, tag string) (err error) {
+	defer observe("item", "AddTag", time.Now(), &err)
+	return repo.Next.AddTag(ctx, itemID, tag)
+}
+
+// RemoveTag measures app.ItemRepo.RemoveTag
+func (repo *ItemRepo) RemoveTag(ctx context.Context, itemID int, tag string) (err error) {
+	defer observe("item", "RemoveTag", time.Now(), &err)
+	return repo.Next.RemoveTag(ctx, itemID, tag)
+}
+
+// Search measures app.ItemRepo.Search
+func (repo *ItemRepo) Search(ctx context.Context, userID int, query string, limit, offset int) (_ []app.ItemMatch, err error) {
+	defer observe("item", "Search", time.Now(), &err)
+	return repo.Next.Search(ctx, userID, query, limit, offset)
+}
+
+// Tags measures app.ItemRepo.Tags
+func (repo *ItemRepo) Tags(ctx context.Context, userID int, prefix string, limit int) (_ []string, err error) {
+	defer observe("item", "Tags", time.Now(), &err)
+	return repo.Next.Tags(ctx, userID, prefix, limit)
+}
+
+// History measures app.ItemRepo.History
+func (repo *ItemRepo) History(ctx context.Context, itemID int) (_ []app.ItemChange, err error) {
+	defer observe("item", "History", time.Now(), &err)
+	return repo.Next.History(ctx, itemID)
+}
@@ -0,0 +1,47 @@
+// Package metrics exposes prometheus metrics about repositories.
+// Repos are decorated so that any implementation gets measured
+package metrics
+
+import (
+	"errors"
+	"time"
+	app "useritem"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	repoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "useritem_repo_duration_seconds",
+		Help:    "Duration of repository calls.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"repo", "method"})
+
+	repoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "useritem_repo_errors_total",
+		Help: "Repository calls that returned an error, by kind of error.",
+	}, []string{"repo", "method", "error"})
+)
+
+// observe records the duration and outcome of a repository call.
+// It is meant to be deferred with a pointer to the named error result
+func observe(repo, method string, start time.Time, err *error) {
+	repoDuration.WithLabelValues(repo, method).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		repoErrors.WithLabelValues(repo, method, errorKind(*err)).Inc()
+	}
+}
+
+// errorKind tells apart the errors every repo implementation shares
+// from unexpected ones
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, app.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, app.ErrConflict):
+		return "conflict"
+	default:
+		return "internal"
+	}
+}
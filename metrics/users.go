@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"time"
+	app "useritem"
+)
+
+// UserRepo measures calls to another app.UserRepo
+type UserRepo struct {
+	Next app.UserRepo
+}
+
+// Create measures app.UserRepo.Create
+func (repo *UserRepo) Create(ctx context.Context, user *app.User, password string) (err error) {
+	defer observe("user", "Create", time.Now(), &err)
+	return repo.Next.Create(ctx, user, password)
+}
+
+// ByID measures app.UserRepo.ByID
+func (repo *UserRepo) ByID(ctx context.Context, id int) (_ *app.User, err error) {
+	defer observe("user", "ByID", time.Now(), &err)
+	return repo.Next.ByID(ctx, id)
+}
+
+// ByEmail measures app.UserRepo.ByEmail
+func (repo *UserRepo) ByEmail(ctx context.Context, email string) (_ *app.User, err error) {
+	defer observe("user", "ByEmail", time.Now(), &err)
+	return repo.Next.ByEmail(ctx, email)
+}
+
+// ByToken measures app.UserRepo.ByToken
+func (repo *UserRepo) ByToken(ctx context.Context, token int) (_ *app.User, err error) {
+	defer observe("user", "ByToken", time.Now(), &err)
+	return repo.Next.ByToken(ctx, token)
+}
+
+// Search measures app.UserRepo.Search
+func (repo *UserRepo) Search(ctx context.Context, query string, limit, offset int) (_ []app.User, err error) {
+	defer observe("user", "Search", time.Now(), &err)
+	return repo.Next.Search(ctx, query, limit, offset)
+}
+
+// UpdateToken measures app.UserRepo.UpdateToken
+func (repo *UserRepo) UpdateToken(ctx context.Context, userID int, newToken int) (err error) {
+	defer observe("user", "UpdateToken", time.Now(), &err)
+	return repo.Next.UpdateToken(ctx, userID, newToken)
+}
+
+// UpdatePassword measures app.UserRepo.UpdatePassword
+func (repo *UserRepo) UpdatePassword(ctx context.Context, userID int, password string) (err error) {
+	defer observe("user", "UpdatePassword", time.Now(), &err)
+	return repo.Next.UpdatePassword(ctx, userID, password)
+}
+
+// UpdateEmail measures app.UserRepo.UpdateEmail
+func (repo *UserRepo) UpdateEmail(ctx context.Context, userID int, email string) (err error) {
+	defer observe("user", "UpdateEmail", time.Now(), &err)
+	return repo.Next.UpdateEmail(ctx, userID, email)
+}
+
+// MarkEmailVerified measures app.UserRepo.MarkEmailVerified
+func (repo *UserRepo) MarkEmailVerified(ctx context.Context, userID int, email string) (err error) {
+	defer observe("user", "MarkEmailVerified", time.Now(), &err)
+	return repo.Next.MarkEmailVerified(ctx, userID, email)
+}
+
+// UpdateTOTP measures app.UserRepo.UpdateTOTP
+func (repo *UserRepo) UpdateTOTP(ctx context.Context, userID int, secret string, enabled bool) (err error) {
+	defer observe("user", "UpdateTOTP", time.Now(), &err)
+	return repo.Next.UpdateTOTP(ctx, userID, secret, enabled)
+}
+
+// UseTOTPStep measures app.UserRepo.UseTOTPStep
+func (repo *UserRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (err error) {
+	defer observe("user", "UseTOTPStep", time.Now(), &err)
+	return repo.Next.UseTOTPStep(ctx, userID, step)
+}
+
+// UpdateRole measures app.UserRepo.UpdateRole
+func (repo *UserRepo) UpdateRole(ctx context.Context, userID int, role app.Role) (err error) {
+	defer observe("user", "UpdateRole", time.Now(), &err)
+	return repo.Next.UpdateRole(ctx, userID, role)
+}
+
+// UpdateDisabled measures app.UserRepo.UpdateDisabled
+func (repo *UserRepo) UpdateDisabled(ctx context.Context, userID int, disabled bool) (err error) {
+	defer observe("user", "UpdateDisabled", time.Now(), &err)
+	return repo.Next.UpdateDisabled(ctx, userID, disabled)
+}
+
+// UpdateLanguage measures app.UserRepo.UpdateLanguage
+func (repo *UserRepo) UpdateLanguage(ctx context.Context, userID int, language string) (err error) {
+	defer observe("user", "UpdateLanguage", time.Now(), &err)
+	return repo.Next.UpdateLanguage(ctx, userID, language)
+}
@@ -1,30 +1,225 @@
-package app
-
-// User represent an user's information
-type User struct {
-	ID       int
-	Name     string
-	Email    string
-	Token    int
-	password string
-}
-
-// SetPassword sets user password
-func (u *User) SetPassword(password string) {
-	u.password = password
-}
-
-// CheckPassword checks if a password is user's password
-func (u *User) CheckPassword(password string) bool {
-	if u.password != password {
-		return false
-	}
-	return true
-}
-
-// Item is something that an user possesses
-type Item struct {
-	UserID int
-	Name   string
-	Price  int
-}
+package app
+
+import "time"
+
+// Role is a set of permissions given to users
+type Role string
+
+const (
+	// RoleUser is the role of regular users
+	RoleUser Role = "user"
+	// RoleAdmin is the role of staff members
+	RoleAdmin Role = "admin"
+)
+
+// Permission allows an user to do something beyond their own stuffs
+type Permission string
+
+const (
+	// PermManageItems allows acting on items of any user
+	PermManageItems Permission = "items:manage"
+	// PermManageUsers allows acting on any user
+	PermManageUsers Permission = "users:manage"
+)
+
+// rolePermissions tells which permissions each role grants
+var rolePermissions = map[Role][]Permission{
+	RoleUser:  nil,
+	RoleAdmin: {PermManageItems, PermManageUsers},
+}
+
+// ValidRole checks if a role exists
+func ValidRole(role Role) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// User represent an user's information
+type User struct {
+	ID            int
+	Name          string
+	Email         string
+	EmailVerified bool
+	Role          Role
+	// Disabled users can neither sign in nor use their sessions
+	Disabled bool
+	Token    int
+	// TOTPSecret is the secret shared with the user's authenticator app.
+	// It is set during enrollment, before TOTPEnabled is
+	TOTPSecret  string
+	TOTPEnabled bool
+	// Language is the BCP 47 tag of the language the user
+	// wants the UI in, empty to go by their browser
+	Language string
+	password string
+}
+
+// HasRole checks if an user has a role
+func (u *User) HasRole(role Role) bool {
+	return u.Role == role
+}
+
+// Can checks if an user's role grants a permission
+func (u *User) Can(perm Permission) bool {
+	for _, p := range rolePermissions[u.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPassword sets user password
+func (u *User) SetPassword(password string) {
+	u.password = password
+}
+
+// CheckPassword checks if a password is user's password
+func (u *User) CheckPassword(password string) bool {
+	if u.password != password {
+		return false
+	}
+	return true
+}
+
+// Item is something that an user possesses
+type Item struct {
+	ID     int
+	UserID int
+	Name   string
+	Price  int
+	// Tags are labels the owner puts on the item to organize them,
+	// in alphabetical order
+	Tags []string
+	// Image is nil for items without a picture
+	Image *Image
+}
+
+// ItemMatch is an item found by a search
+type ItemMatch struct {
+	Item Item
+	// Highlights are the name of the item cut around
+	// the parts matching the search
+	Highlights []Highlight
+}
+
+// Highlight is a part of a text found by a search
+type Highlight struct {
+	Text string
+	// Match tells whether this part matches the search
+	Match bool
+}
+
+// Image is a picture of an item, kept in a BlobStore
+type Image struct {
+	// Key is where the picture is in the blob store
+	Key string
+	// ThumbnailKey is where a small JPEG version of the picture is
+	ThumbnailKey string
+	ContentType  string
+	Size         int64
+}
+
+// ItemChangeKind tells how an item changed
+type ItemChangeKind string
+
+const (
+	// ItemCreated is the first change of an item
+	ItemCreated ItemChangeKind = "created"
+	// ItemUpdated is a change of the name, price, tags or image of an item
+	ItemUpdated ItemChangeKind = "updated"
+	// ItemDeleted is the last change of an item
+	ItemDeleted ItemChangeKind = "deleted"
+)
+
+// ItemChange is an entry of the history of an item.
+// Entries are only ever added, never changed or removed,
+// and they outlive the item
+type ItemChange struct {
+	ID     int
+	ItemID int
+	Kind   ItemChangeKind
+	// ActorID is the id of the user who made the change,
+	// 0 if it was not made on behalf of an user
+	ActorID int
+	// RequestID is the id of the request the change was made in,
+	// to find it in logs
+	RequestID string
+	At        time.Time
+	// Before and After are the item before and after the change.
+	// Before is nil for created items, After for deleted ones
+	Before *Item
+	After  *Item
+}
+
+// TokenKind tells what a one-time token can be used for
+type TokenKind string
+
+const (
+	// TokenPasswordReset allows an user to choose a new password
+	TokenPasswordReset TokenKind = "password_reset"
+	// TokenEmailVerification proves that an user owns an email address
+	TokenEmailVerification TokenKind = "email_verification"
+	// TokenTwoFactor is a challenge proving that an user passed
+	// the first signin step and must now give a second factor
+	TokenTwoFactor TokenKind = "two_factor"
+)
+
+// OneTimeToken is a single-use secret handed to an user.
+// Only the hash of the secret is kept, the secret itself
+// is known to the user only
+type OneTimeToken struct {
+	Kind      TokenKind
+	UserID    int
+	Hash      string
+	ExpiresAt time.Time
+	// Email is the address the token was sent to
+	Email string
+}
+
+// Scope is a permission granted to an access token
+type Scope string
+
+const (
+	// ScopeItemsRead allows listing items
+	ScopeItemsRead Scope = "items:read"
+	// ScopeItemsWrite allows creating items
+	ScopeItemsWrite Scope = "items:write"
+	// ScopeAccount allows managing the account itself,
+	// including its access tokens.
+	// It is held by sessions only and can never be granted to an access token
+	ScopeAccount Scope = "account"
+)
+
+// GrantableScopes are the scopes an user can grant to an access token
+var GrantableScopes = []Scope{ScopeItemsRead, ScopeItemsWrite}
+
+// AccessToken is a long-lived token an user mints for scripts
+// to call the JSON API on their behalf
+type AccessToken struct {
+	ID     int
+	UserID int
+	Name   string
+	// Hash is the hash of the token, the token itself
+	// is only shown to the user once, at creation
+	Hash   string
+	Scopes []Scope
+	// ExpiresAt is zero for tokens that never expire
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// HasScope checks if an access token has been granted a scope
+func (t *AccessToken) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired checks if an access token is expired at a given time
+func (t *AccessToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
@@ -1,23 +1,79 @@
-package app
-
-import "errors"
-
-var (
-	// ErrNotFound is an implementation-independent error
-	// that should be return by any repo implementation
-	// when a record is not found
-	ErrNotFound = errors.New("app: the requested resource is not found")
-)
-
-// UserRepo is an interface for interact with users in database
-type UserRepo interface {
-	ByEmail(email string) (*User, error)
-	ByToken(token int) (*User, error)
-	UpdateToken(userID int, newToken int) error
-}
-
-// ItemRepo is an interface for interact with items in database
-type ItemRepo interface {
-	ByUser(userID int) ([]Item, error)
-	Create(item *Item) error
-}
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is an implementation-independent error
+	// that should be return by any repo implementation
+	// when a record is not found
+	ErrNotFound = errors.New("app: the requested resource is not found")
+
+	// ErrConflict is an implementation-independent error
+	// that should be return by any repo implementation
+	// when a record clashes with an existing one
+	ErrConflict = errors.New("app: the resource already exists")
+)
+
+// UserRepo is an interface for interact with users in database
+type UserRepo interface {
+	Create(ctx context.Context, user *User, password string) error
+	ByID(ctx context.Context, id int) (*User, error)
+	ByEmail(ctx context.Context, email string) (*User, error)
+	ByToken(ctx context.Context, token int) (*User, error)
+	Search(ctx context.Context, query string, limit, offset int) ([]User, error)
+	UpdateToken(ctx context.Context, userID int, newToken int) error
+	UpdatePassword(ctx context.Context, userID int, password string) error
+	UpdateEmail(ctx context.Context, userID int, email string) error
+	MarkEmailVerified(ctx context.Context, userID int, email string) error
+	UpdateTOTP(ctx context.Context, userID int, secret string, enabled bool) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+	UpdateRole(ctx context.Context, userID int, role Role) error
+	UpdateDisabled(ctx context.Context, userID int, disabled bool) error
+	UpdateLanguage(ctx context.Context, userID int, language string) error
+}
+
+// AccessTokenRepo is an interface for interact with access tokens in database
+type AccessTokenRepo interface {
+	Create(ctx context.Context, token *AccessToken) error
+	ByHash(ctx context.Context, hash string) (*AccessToken, error)
+	ByUser(ctx context.Context, userID int) ([]AccessToken, error)
+	Delete(ctx context.Context, userID int, id int) error
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// RecoveryCodeRepo is an interface for interact with
+// two-factor recovery codes in database
+type RecoveryCodeRepo interface {
+	Replace(ctx context.Context, userID int, hashes []string) error
+	Use(ctx context.Context, userID int, hash string) error
+}
+
+// ItemRepo is an interface for interact with items in database
+type ItemRepo interface {
+	ByID(ctx context.Context, id int) (*Item, error)
+	ByUser(ctx context.Context, userID int) ([]Item, error)
+	ByUserAfter(ctx context.Context, userID, afterID, limit int) ([]Item, error)
+	ByTag(ctx context.Context, userID int, tag string) ([]Item, error)
+	Create(ctx context.Context, item *Item) error
+	CreateAll(ctx context.Context, items []Item) error
+	Update(ctx context.Context, item *Item) error
+	UpdateAll(ctx context.Context, items []Item) error
+	Delete(ctx context.Context, id int) error
+	DeleteAll(ctx context.Context, ids []int) error
+	AddTag(ctx context.Context, itemID int, tag string) error
+	RemoveTag(ctx context.Context, itemID int, tag string) error
+	Tags(ctx context.Context, userID int, prefix string, limit int) ([]string, error)
+	Search(ctx context.Context, userID int, query string, limit, offset int) ([]ItemMatch, error)
+	History(ctx context.Context, itemID int) ([]ItemChange, error)
+}
+
+// TokenRepo is an interface for interact with one-time tokens in database
+type TokenRepo interface {
+	Create(ctx context.Context, token *OneTimeToken) error
+	Consume(ctx context.Context, kind TokenKind, hash string, now time.Time) (*OneTimeToken, error)
+	DeleteByUser(ctx context.Context, kind TokenKind, userID int) error
+}
@@ -0,0 +1,111 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	app "useritem"
+)
+
+// AccessTokenRepo is a Sqlite specific implementation of the access token repository
+type AccessTokenRepo struct {
+	DB *sql.DB
+}
+
+const accessTokenColumns = "id, userid, name, hash, scopes, expires_at, created_at"
+
+// scanAccessToken reads an access token selected with accessTokenColumns
+// if there is no row, return app.ErrNotFound
+func scanAccessToken(row scanner) (*app.AccessToken, error) {
+	var token app.AccessToken
+	var scopes string
+	var expiresAt, createdAt int64
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Hash, &scopes, &expiresAt, &createdAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, app.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	for _, scope := range strings.Fields(scopes) {
+		token.Scopes = append(token.Scopes, app.Scope(scope))
+	}
+	if expiresAt != 0 {
+		token.ExpiresAt = time.Unix(expiresAt, 0)
+	}
+	token.CreatedAt = time.Unix(createdAt, 0)
+	return &token, nil
+}
+
+// Create insert new access token into database and set its id
+// return an error
+func (repo *AccessTokenRepo) Create(ctx context.Context, token *app.AccessToken) error {
+	scopes := make([]string, 0, len(token.Scopes))
+	for _, scope := range token.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+	var expiresAt int64
+	if !token.ExpiresAt.IsZero() {
+		expiresAt = token.ExpiresAt.Unix()
+	}
+	res, err := exec(ctx, repo.DB, "insert into access_tokens(userid,name,hash,scopes,expires_at,created_at) values (?,?,?,?,?,?)",
+		token.UserID, token.Name, token.Hash, strings.Join(scopes, " "), expiresAt, token.CreatedAt.Unix())
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	token.ID = int(id)
+	return nil
+}
+
+// ByHash will look for an access token with the same hash
+// return *app.AccessToken and an error
+// if not found, return app.ErrNotFound
+// if any SQL-specific error happens, pass the error through
+func (repo *AccessTokenRepo) ByHash(ctx context.Context, hash string) (*app.AccessToken, error) {
+	row := queryRow(ctx, repo.DB, "select "+accessTokenColumns+" from access_tokens where hash=?", hash)
+	return scanAccessToken(row)
+}
+
+// ByUser will look for all access tokens that belong to an user with specific user id
+// return slice of app.AccessToken and an error
+func (repo *AccessTokenRepo) ByUser(ctx context.Context, userID int) ([]app.AccessToken, error) {
+	rows, err := queryRows(ctx, repo.DB, "select "+accessTokenColumns+" from access_tokens where userid=? order by id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []app.AccessToken
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// Delete will delete an access token of an user
+// return an error
+// if the user has no such token, return app.ErrNotFound
+func (repo *AccessTokenRepo) Delete(ctx context.Context, userID int, id int) error {
+	res, err := exec(ctx, repo.DB, "delete from access_tokens where id=? and userid=?", id, userID)
+	if err != nil {
+		return err
+	}
+	return mustAffect(res)
+}
+
+// DeleteByUser will delete all access tokens of an user
+// return an error
+func (repo *AccessTokenRepo) DeleteByUser(ctx context.Context, userID int) error {
+	_, err := exec(ctx, repo.DB, "delete from access_tokens where userid=?", userID)
+	return err
+}
@@ -0,0 +1,34 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+	app "useritem"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// isUniqueViolation tells whether an error is caused by a unique constraint
+func isUniqueViolation(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// mustAffect returns app.ErrNotFound when a statement changed no row
+func mustAffect(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return app.ErrNotFound
+	}
+	return nil
+}
+
+// escapeLike escapes the wildcards of a like pattern, using a backslash as escape character
+func escapeLike(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "%", "\\%", -1)
+	return strings.Replace(s, "_", "\\_", -1)
+}
@@ -0,0 +1,155 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"time"
+	app "useritem"
+	appcontext "useritem/context"
+)
+
+// itemSnapshot is an item as kept in its history, in JSON
+type itemSnapshot struct {
+	UserID int            `json:"user_id"`
+	Name   string         `json:"name"`
+	Price  int            `json:"price"`
+	Tags   []string       `json:"tags"`
+	Image  *imageSnapshot `json:"image,omitempty"`
+}
+
+type imageSnapshot struct {
+	Key          string `json:"key"`
+	ThumbnailKey string `json:"thumbnail_key"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+}
+
+// History will look for the changes of an item, newest first.
+// Items keep their history once deleted
+// return slice of app.ItemChange and an error
+func (repo *ItemRepo) History(ctx context.Context, itemID int) ([]app.ItemChange, error) {
+	rows, err := queryRows(ctx, repo.DB, `select id, itemid, kind, actorid, requestid, at, before, after
+		from item_history where itemid=? order by id desc`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var changes []app.ItemChange
+	for rows.Next() {
+		var change app.ItemChange
+		var at int64
+		var before, after sql.NullString
+		err = rows.Scan(&change.ID, &change.ItemID, &change.Kind, &change.ActorID, &change.RequestID, &at, &before, &after)
+		if err != nil {
+			return nil, err
+		}
+		change.At = time.Unix(at, 0)
+		change.Before, err = readSnapshot(itemID, before)
+		if err != nil {
+			return nil, err
+		}
+		change.After, err = readSnapshot(itemID, after)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// recordChange adds an entry to the history of an item, with the item
+// before the change and as it is now. Changes that leave the item as it was
+// are not recorded. The user and request the change is made for
+// are taken from the context
+func recordChange(ctx context.Context, q querier, kind app.ItemChangeKind, itemID int, before *app.Item) error {
+	var after *app.Item
+	if kind != app.ItemDeleted {
+		var err error
+		after, err = itemByID(ctx, q, itemID)
+		if err != nil {
+			return err
+		}
+	}
+	if before != nil && after != nil && reflect.DeepEqual(before, after) {
+		return nil
+	}
+	beforeJSON, err := writeSnapshot(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := writeSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	var actorID int
+	user, err := appcontext.User(ctx)
+	if err != nil {
+		return err
+	}
+	if user != nil {
+		actorID = user.ID
+	}
+	_, err = exec(ctx, q, `insert into item_history(itemid, kind, actorid, requestid, at, before, after)
+		values (?,?,?,?,?,?,?)`, itemID, kind, actorID, appcontext.RequestID(ctx), time.Now().Unix(), beforeJSON, afterJSON)
+	return err
+}
+
+// writeSnapshot encodes an item for its history, nil items as null
+func writeSnapshot(item *app.Item) (sql.NullString, error) {
+	if item == nil {
+		return sql.NullString{}, nil
+	}
+	snapshot := itemSnapshot{
+		UserID: item.UserID,
+		Name:   item.Name,
+		Price:  item.Price,
+		Tags:   item.Tags,
+	}
+	if item.Image != nil {
+		snapshot.Image = &imageSnapshot{
+			Key:          item.Image.Key,
+			ThumbnailKey: item.Image.ThumbnailKey,
+			ContentType:  item.Image.ContentType,
+			Size:         item.Image.Size,
+		}
+	}
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// readSnapshot decodes an item of the history of an item
+func readSnapshot(itemID int, s sql.NullString) (*app.Item, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	var snapshot itemSnapshot
+	err := json.Unmarshal([]byte(s.String), &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	item := app.Item{
+		ID:     itemID,
+		UserID: snapshot.UserID,
+		Name:   snapshot.Name,
+		Price:  snapshot.Price,
+		Tags:   snapshot.Tags,
+	}
+	if item.Tags == nil {
+		item.Tags = []string{}
+	}
+	if snapshot.Image != nil {
+		item.Image = &app.Image{
+			Key:          snapshot.Image.Key,
+			ThumbnailKey: snapshot.Image.ThumbnailKey,
+			ContentType:  snapshot.Image.ContentType,
+			Size:         snapshot.Image.Size,
+		}
+	}
+	return &item, nil
+}
@@ -1,44 +1,557 @@
-package sqlite
-
-import (
-	"database/sql"
-	"log"
-	app "useritem"
-)
-
-// ItemRepo is a Sqlite specific implementation of the item repository
-type ItemRepo struct {
-	DB *sql.DB
-}
-
-// ByUser will look for all items that belong to an user with specific user id
-// return slice of app.Item and an error
-func (repo *ItemRepo) ByUser(userID int) ([]app.Item, error) {
-	rows, err := repo.DB.Query("select userid,name,price from items where userid=?", userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []app.Item
-	for rows.Next() {
-		var item app.Item
-		err = rows.Scan(&item.UserID, &item.Name, &item.Price)
-		if err != nil {
-			log.Printf("Failed to scan item: %v\n", err)
-			continue
-		}
-		items = append(items, item)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
-	return items, nil
-}
-
-// Create insert new item into database
-// return an error
-func (repo *ItemRepo) Create(item *app.Item) error {
-	_, err := repo.DB.Exec("insert into items(userid,name,price) values (?,?,?)", item.UserID, item.Name, item.Price)
-	return err
-}
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	app "useritem"
+)
+
+// ItemRepo is a Sqlite specific implementation of the item repository
+type ItemRepo struct {
+	DB *sql.DB
+}
+
+// itemFields are the columns of an item i along its image and its tags,
+// joined by commas
+const itemFields = `i.id, i.userid, i.name, i.price,
+	i.image_key, i.image_thumbnail_key, i.image_content_type, i.image_size,
+	coalesce((select group_concat(t.name, ',') from item_tags it join tags t on t.id=it.tagid where it.itemid=i.id), '')`
+
+// itemColumns selects an item
+const itemColumns = itemFields + " from items i"
+
+// Highlighted parts of texts are put between these characters,
+// which are not found in what users type
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+// scanItem reads an item selected with itemColumns,
+// and the extra columns selected after them
+func scanItem(row scanner, extra ...interface{}) (*app.Item, error) {
+	var item app.Item
+	var image app.Image
+	var tags string
+	dest := []interface{}{&item.ID, &item.UserID, &item.Name, &item.Price,
+		&image.Key, &image.ThumbnailKey, &image.ContentType, &image.Size, &tags}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	if image.Key != "" {
+		item.Image = &image
+	}
+	item.Tags = []string{}
+	if tags != "" {
+		item.Tags = strings.Split(tags, ",")
+		sort.Strings(item.Tags)
+	}
+	return &item, nil
+}
+
+// ByID will look for an item with a specific id
+// return *app.Item and an error
+// if not found, return app.ErrNotFound
+// if any SQL-specific error happens, pass the error through
+func (repo *ItemRepo) ByID(ctx context.Context, id int) (*app.Item, error) {
+	return itemByID(ctx, repo.DB, id)
+}
+
+// itemByID looks for an item with a specific id
+// if not found, return app.ErrNotFound
+func itemByID(ctx context.Context, q querier, id int) (*app.Item, error) {
+	row := queryRow(ctx, q, "select "+itemColumns+" where i.id=?", id)
+	item, err := scanItem(row)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, app.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return item, nil
+}
+
+// ByUser will look for all items that belong to an user with specific user id
+// return slice of app.Item and an error
+func (repo *ItemRepo) ByUser(ctx context.Context, userID int) ([]app.Item, error) {
+	rows, err := queryRows(ctx, repo.DB, "select "+itemColumns+" where i.userid=? order by i.id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanItems(rows)
+}
+
+// ByUserAfter will look for at most limit items of an user
+// whose id is after afterID, by id, to go through them a page at a time
+// return slice of app.Item and an error
+func (repo *ItemRepo) ByUserAfter(ctx context.Context, userID, afterID, limit int) ([]app.Item, error) {
+	rows, err := queryRows(ctx, repo.DB, "select "+itemColumns+" where i.userid=? and i.id>? order by i.id limit ?",
+		userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanItems(rows)
+}
+
+// ByTag will look for the items of an user with a tag
+// return slice of app.Item and an error
+func (repo *ItemRepo) ByTag(ctx context.Context, userID int, tag string) ([]app.Item, error) {
+	rows, err := queryRows(ctx, repo.DB, "select "+itemColumns+` where i.userid=? and i.id in (
+		select it.itemid from item_tags it join tags t on t.id=it.tagid where t.userid=? and t.name=?)
+		order by i.id`, userID, userID, tag)
+	if err != nil {
+		return nil, err
+	}
+	return scanItems(rows)
+}
+
+func scanItems(rows *sql.Rows) ([]app.Item, error) {
+	defer rows.Close()
+	var items []app.Item
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			log.Printf("Failed to scan item: %v\n", err)
+			continue
+		}
+		items = append(items, *item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Create insert new item into database along its image and tags and set its id
+// return an error
+func (repo *ItemRepo) Create(ctx context.Context, item *app.Item) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := createItem(ctx, tx, item)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	item.ID = id
+	return nil
+}
+
+// CreateAll insert new items into database in a single transaction,
+// so that either all of them are created or none, and set their ids
+// return an error
+func (repo *ItemRepo) CreateAll(ctx context.Context, items []app.Item) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(items))
+	for i := range items {
+		ids[i], err = createItem(ctx, tx, &items[i])
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].ID = ids[i]
+	}
+	return nil
+}
+
+// createItem inserts an item along its image and tags,
+// and records its creation in its history
+// return the id of the item and an error
+func createItem(ctx context.Context, q querier, item *app.Item) (int, error) {
+	image := imageColumns(item.Image)
+	res, err := exec(ctx, q, `insert into items(userid,name,price,image_key,image_thumbnail_key,image_content_type,image_size)
+		values (?,?,?,?,?,?,?)`, item.UserID, item.Name, item.Price, image.Key, image.ThumbnailKey, image.ContentType, image.Size)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, tag := range item.Tags {
+		err = addTag(ctx, q, int(id), item.UserID, tag)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = recordChange(ctx, q, app.ItemCreated, int(id), nil)
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// Update will update the name, price, image and tags of an item
+// return an error
+// if not found, return app.ErrNotFound
+func (repo *ItemRepo) Update(ctx context.Context, item *app.Item) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateItem(ctx, tx, item)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateAll will update items in a single transaction,
+// so that either all of them are updated or none
+// return an error
+// if any is not found, return app.ErrNotFound
+func (repo *ItemRepo) UpdateAll(ctx context.Context, items []app.Item) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range items {
+		err = updateItem(ctx, tx, &items[i])
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// updateItem updates the name, price, image and tags of an item,
+// and records the change in its history
+// if not found, return app.ErrNotFound
+func updateItem(ctx context.Context, q querier, item *app.Item) error {
+	before, err := itemByID(ctx, q, item.ID)
+	if err != nil {
+		return err
+	}
+	image := imageColumns(item.Image)
+	res, err := exec(ctx, q, `update items set name=?, price=?,
+		image_key=?, image_thumbnail_key=?, image_content_type=?, image_size=? where id=?`,
+		item.Name, item.Price, image.Key, image.ThumbnailKey, image.ContentType, image.Size, item.ID)
+	if err != nil {
+		return err
+	}
+	err = mustAffect(res)
+	if err != nil {
+		return err
+	}
+	_, err = exec(ctx, q, "delete from item_tags where itemid=?", item.ID)
+	if err != nil {
+		return err
+	}
+	for _, tag := range item.Tags {
+		err = addTag(ctx, q, item.ID, item.UserID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	err = deleteUnusedTags(ctx, q, item.UserID)
+	if err != nil {
+		return err
+	}
+	return recordChange(ctx, q, app.ItemUpdated, item.ID, before)
+}
+
+// Delete will delete an item with a specific id
+// return an error
+// if not found, return app.ErrNotFound
+func (repo *ItemRepo) Delete(ctx context.Context, id int) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteItem(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteAll will delete items with specific ids in a single transaction,
+// so that either all of them are deleted or none
+// return an error
+// if any is not found, return app.ErrNotFound
+func (repo *ItemRepo) DeleteAll(ctx context.Context, ids []int) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		err = deleteItem(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteItem deletes an item along its tags,
+// and records the deletion in its history
+// if not found, return app.ErrNotFound
+func deleteItem(ctx context.Context, q querier, id int) error {
+	before, err := itemByID(ctx, q, id)
+	if err != nil {
+		return err
+	}
+	_, err = exec(ctx, q, "delete from items where id=?", id)
+	if err != nil {
+		return err
+	}
+	_, err = exec(ctx, q, "delete from item_tags where itemid=?", id)
+	if err != nil {
+		return err
+	}
+	err = deleteUnusedTags(ctx, q, before.UserID)
+	if err != nil {
+		return err
+	}
+	return recordChange(ctx, q, app.ItemDeleted, id, before)
+}
+
+// AddTag will put a tag on an item, if it is not on it yet
+// return an error
+// if the item is not found, return app.ErrNotFound
+func (repo *ItemRepo) AddTag(ctx context.Context, itemID int, tag string) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := itemByID(ctx, tx, itemID)
+	if err != nil {
+		return err
+	}
+	err = addTag(ctx, tx, itemID, before.UserID, tag)
+	if err != nil {
+		return err
+	}
+	err = recordChange(ctx, tx, app.ItemUpdated, itemID, before)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveTag will take a tag off an item
+// return an error
+// if the item does not have the tag, return app.ErrNotFound
+func (repo *ItemRepo) RemoveTag(ctx context.Context, itemID int, tag string) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := itemByID(ctx, tx, itemID)
+	if err != nil {
+		return err
+	}
+	res, err := exec(ctx, tx, "delete from item_tags where itemid=? and tagid=(select id from tags where userid=? and name=?)",
+		itemID, before.UserID, tag)
+	if err != nil {
+		return err
+	}
+	err = mustAffect(res)
+	if err != nil {
+		return err
+	}
+	err = deleteUnusedTags(ctx, tx, before.UserID)
+	if err != nil {
+		return err
+	}
+	err = recordChange(ctx, tx, app.ItemUpdated, itemID, before)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Tags will look for the tags of an user starting with a prefix,
+// in alphabetical order
+// return a slice of tags and an error
+func (repo *ItemRepo) Tags(ctx context.Context, userID int, prefix string, limit int) ([]string, error) {
+	rows, err := queryRows(ctx, repo.DB, `select name from tags where userid=? and name like ? escape '\'
+		order by name limit ?`, userID, escapeLike(prefix)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		err = rows.Scan(&tag)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// imageColumns returns what to store in the image columns of an item,
+// which are empty for items without image
+func imageColumns(image *app.Image) app.Image {
+	if image == nil {
+		return app.Image{}
+	}
+	return *image
+}
+
+// Search will look for the items of an user whose name or tags
+// have every word of a query, or words starting with them.
+// Items are ranked by relevance, matches in the name count more than in tags.
+// Builds without FTS5 fall back to searchUnindexed
+// return slice of app.ItemMatch and an error
+func (repo *ItemRepo) Search(ctx context.Context, userID int, query string, limit, offset int) ([]app.ItemMatch, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+	indexed, err := searchIndexed(ctx, repo.DB)
+	if err != nil {
+		return nil, err
+	}
+	if !indexed {
+		return repo.searchUnindexed(ctx, userID, strings.Fields(query), limit, offset)
+	}
+	rows, err := queryRows(ctx, repo.DB, "select "+itemFields+`, highlight(items_fts, 0, ?, ?)
+		from items_fts join items i on i.id=items_fts.rowid
+		where items_fts match ? and i.userid=?
+		order by bm25(items_fts, 10.0, 1.0), i.id limit ? offset ?`,
+		highlightStart, highlightEnd, match, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var matches []app.ItemMatch
+	for rows.Next() {
+		var highlight string
+		item, err := scanItem(rows, &highlight)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, app.ItemMatch{
+			Item:       *item,
+			Highlights: splitHighlights(highlight),
+		})
+	}
+	return matches, rows.Err()
+}
+
+// searchUnindexed will look for the items of an user whose name or tags
+// contain every word, without the search index. It is slower, items
+// are not ranked and words only match with the same accents
+// return slice of app.ItemMatch and an error
+func (repo *ItemRepo) searchUnindexed(ctx context.Context, userID int, words []string, limit, offset int) ([]app.ItemMatch, error) {
+	where := "i.userid=?"
+	args := []interface{}{userID}
+	for _, word := range words {
+		pattern := "%" + escapeLike(word) + "%"
+		where += ` and (i.name like ? escape '\' or exists (select 1 from item_tags it join tags t on t.id=it.tagid
+			where it.itemid=i.id and t.name like ? escape '\'))`
+		args = append(args, pattern, pattern)
+	}
+	args = append(args, limit, offset)
+	rows, err := queryRows(ctx, repo.DB, "select "+itemColumns+" where "+where+" order by i.id limit ? offset ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, err
+	}
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	matches := make([]app.ItemMatch, len(items))
+	for i, item := range items {
+		name := re.ReplaceAllString(item.Name, highlightStart+"$0"+highlightEnd)
+		matches[i] = app.ItemMatch{Item: item, Highlights: splitHighlights(name)}
+	}
+	return matches, nil
+}
+
+// ftsQuery turns what an user typed into an FTS5 query
+// matching every word, or words starting with them.
+// Words are quoted so that FTS5 operators are taken literally
+func ftsQuery(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		terms = append(terms, `"`+strings.Replace(word, `"`, `""`, -1)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// splitHighlights cuts a text highlighted by FTS5
+// around its highlighted parts
+func splitHighlights(s string) []app.Highlight {
+	var highlights []app.Highlight
+	for s != "" {
+		start := strings.Index(s, highlightStart)
+		if start < 0 {
+			return append(highlights, app.Highlight{Text: s})
+		}
+		if start > 0 {
+			highlights = append(highlights, app.Highlight{Text: s[:start]})
+		}
+		s = s[start+len(highlightStart):]
+		end := strings.Index(s, highlightEnd)
+		if end < 0 {
+			end = len(s)
+		}
+		highlights = append(highlights, app.Highlight{Text: s[:end], Match: true})
+		s = strings.TrimPrefix(s[end:], highlightEnd)
+	}
+	return highlights
+}
+
+// addTag puts a tag on an item, creating the tag
+// if its owner does not have it yet
+func addTag(ctx context.Context, q querier, itemID, userID int, tag string) error {
+	_, err := exec(ctx, q, "insert or ignore into tags(userid,name) values (?,?)", userID, tag)
+	if err != nil {
+		return err
+	}
+	_, err = exec(ctx, q, "insert or ignore into item_tags(itemid,tagid) select ?, id from tags where userid=? and name=?",
+		itemID, userID, tag)
+	return err
+}
+
+// deleteUnusedTags deletes the tags of an user that are on no item
+func deleteUnusedTags(ctx context.Context, q querier, userID int) error {
+	_, err := exec(ctx, q, "delete from tags where userid=? and id not in (select tagid from item_tags)", userID)
+	return err
+}
@@ -0,0 +1,286 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migrations holds every schema change in the order they must be applied.
+// The schema version of a database is the number of applied migrations,
+// kept in sqlite's user_version pragma.
+// Never edit an existing migration, append a new one instead
+var migrations = []string{
+	// 1: initial schema
+	`create table if not exists users(
+	id int primary key not null,
+	name text not null,
+	email text not null,
+	password text not null,
+	token text
+	);
+	create table if not exists items(
+	userid int not null,
+	name text not null,
+	price int not null);`,
+
+	// 2: one-time tokens
+	`create table tokens(
+	hash text primary key not null,
+	kind text not null,
+	userid int not null,
+	expires_at int not null,
+	used_at int
+	);
+	create index tokens_userid on tokens(userid);`,
+
+	// 3: email verification
+	`alter table users add column email_verified int not null default 0;
+	alter table tokens add column email text not null default '';
+	create unique index users_email on users(email);`,
+
+	// 4: two-factor authentication
+	`alter table users add column totp_secret text not null default '';
+	alter table users add column totp_enabled int not null default 0;
+	create table recovery_codes(
+	userid int not null,
+	hash text not null,
+	used_at int
+	);
+	create index recovery_codes_userid on recovery_codes(userid);`,
+
+	// 5: access tokens
+	`create table access_tokens(
+	id integer primary key autoincrement,
+	userid int not null,
+	name text not null,
+	hash text not null unique,
+	scopes text not null,
+	expires_at int not null,
+	created_at int not null
+	);
+	create index access_tokens_userid on access_tokens(userid);`,
+
+	// 6: roles and item ids
+	`alter table users add column role text not null default 'user';
+	create table items_new(
+	id integer primary key autoincrement,
+	userid int not null,
+	name text not null,
+	price int not null);
+	insert into items_new(userid,name,price) select userid,name,price from items;
+	drop table items;
+	alter table items_new rename to items;
+	create index items_userid on items(userid);`,
+
+	// 7: disabled accounts
+	`alter table users add column disabled int not null default 0;`,
+
+	// 8: preferred languages
+	`alter table users add column language text not null default '';`,
+
+	// 9: item tags
+	`create table tags(
+	id integer primary key autoincrement,
+	userid int not null,
+	name text not null,
+	unique(userid, name)
+	);
+	create table item_tags(
+	itemid int not null,
+	tagid int not null,
+	primary key(itemid, tagid)
+	);
+	create index item_tags_tagid on item_tags(tagid);`,
+
+	// 10: item images
+	`alter table items add column image_key text not null default '';
+	alter table items add column image_thumbnail_key text not null default '';
+	alter table items add column image_content_type text not null default '';
+	alter table items add column image_size int not null default 0;`,
+
+	// 11: item search. It needs FTS5, builds without it skip this migration
+	// and search items without index, see syncSearchIndex
+	searchIndexTable + searchIndexFill + searchIndexTriggers,
+
+	// 12: item history. Entries are JSON snapshots of items,
+	// the triggers keep them from ever being changed
+	`create table item_history(
+	id integer primary key autoincrement,
+	itemid int not null,
+	kind text not null,
+	actorid int not null,
+	requestid text not null,
+	at int not null,
+	before text,
+	after text
+	);
+	create index item_history_itemid on item_history(itemid);
+	create trigger item_history_no_update before update on item_history begin
+		select raise(abort, 'item history is append-only');
+	end;
+	create trigger item_history_no_delete before delete on item_history begin
+		select raise(abort, 'item history is append-only');
+	end;`,
+
+	// 13: time step of the last accepted two-factor code, to refuse replays
+	`alter table users add column totp_step int not null default 0;`,
+}
+
+// searchMigration is the version of the migration creating the search index
+const searchMigration = 11
+
+// searchIndexTable is the FTS5 index of the names and tags of items.
+// The tags of an item are joined by spaces in the tags column
+const searchIndexTable = `create virtual table items_fts using fts5(name, tags, tokenize='unicode61 remove_diacritics 2');`
+
+// searchIndexFill indexes every item
+const searchIndexFill = `
+	delete from items_fts;
+	insert into items_fts(rowid, name, tags) select i.id, i.name, coalesce((select group_concat(t.name, ' ')
+		from item_tags it join tags t on t.id=it.tagid where it.itemid=i.id), '') from items i;`
+
+// searchIndexTriggers keep the index up to date with items and their tags
+const searchIndexTriggers = `
+	create trigger items_fts_insert after insert on items begin
+		insert into items_fts(rowid, name, tags) values (new.id, new.name, '');
+	end;
+	create trigger items_fts_update after update of name on items begin
+		update items_fts set name=new.name where rowid=new.id;
+	end;
+	create trigger items_fts_delete after delete on items begin
+		delete from items_fts where rowid=old.id;
+	end;
+	create trigger item_tags_fts_insert after insert on item_tags begin
+		update items_fts set tags=(select group_concat(t.name, ' ')
+			from item_tags it join tags t on t.id=it.tagid where it.itemid=new.itemid) where rowid=new.itemid;
+	end;
+	create trigger item_tags_fts_delete after delete on item_tags begin
+		update items_fts set tags=coalesce((select group_concat(t.name, ' ')
+			from item_tags it join tags t on t.id=it.tagid where it.itemid=old.itemid), '') where rowid=old.itemid;
+	end;`
+
+// searchIndexDropTriggers stops updating the index
+const searchIndexDropTriggers = `
+	drop trigger if exists items_fts_insert;
+	drop trigger if exists items_fts_update;
+	drop trigger if exists items_fts_delete;
+	drop trigger if exists item_tags_fts_insert;
+	drop trigger if exists item_tags_fts_delete;`
+
+// HealthChecker checks the database can be reached
+// and its schema is up to date
+type HealthChecker struct {
+	DB *sql.DB
+}
+
+// Check pings the database and compares its schema version
+// with the migrations this binary knows
+// return an error
+func (c *HealthChecker) Check(ctx context.Context) error {
+	err := c.DB.PingContext(ctx)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = c.DB.QueryRowContext(ctx, "pragma user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version != len(migrations) {
+		return fmt.Errorf("sqlite: schema version is %d, want %d", version, len(migrations))
+	}
+	return nil
+}
+
+// Migrate brings the database schema up to date,
+// then syncs the search index with what this build supports
+// return an error
+func Migrate(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("pragma user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	fts, err := hasFTS5(db)
+	if err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if version+1 != searchMigration || fts {
+			_, err = tx.Exec(migrations[version])
+		}
+		if err == nil {
+			_, err = tx.Exec(fmt.Sprintf("pragma user_version=%d", version+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite: migration %d failed: %v", version+1, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return syncSearchIndex(db, fts)
+}
+
+// hasFTS5 tells whether sqlite was built with FTS5,
+// which needs the sqlite_fts5 build tag
+func hasFTS5(db *sql.DB) (bool, error) {
+	var used bool
+	err := db.QueryRow("select sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used)
+	return used, err
+}
+
+// syncSearchIndex makes the search index fit the build, as databases
+// may be used by builds with and without FTS5. Without it, the triggers
+// updating the index are dropped so that items can still be written,
+// and items are searched without index. With it, an index that is
+// missing or was left behind by such a build is created or rebuilt
+func syncSearchIndex(db *sql.DB, fts bool) error {
+	var tables, triggers int
+	err := db.QueryRow(`select count(*) from sqlite_master where type='table' and name='items_fts'`).Scan(&tables)
+	if err != nil {
+		return err
+	}
+	err = db.QueryRow(`select count(*) from sqlite_master where type='trigger' and name='items_fts_insert'`).Scan(&triggers)
+	if err != nil {
+		return err
+	}
+	var schema string
+	switch {
+	case !fts && triggers > 0:
+		log.Println("sqlite: built without FTS5, items are searched without index")
+		schema = searchIndexDropTriggers
+	case fts && tables == 0:
+		schema = searchIndexTable + searchIndexFill + searchIndexTriggers
+	case fts && triggers == 0:
+		schema = searchIndexDropTriggers + searchIndexFill + searchIndexTriggers
+	default:
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(schema)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("sqlite: search index failed: %v", err)
+	}
+	return tx.Commit()
+}
+
+// searchIndexed tells whether items are searched with the FTS5 index
+// return a bool and an error
+func searchIndexed(ctx context.Context, q querier) (bool, error) {
+	var triggers int
+	err := queryRow(ctx, q, `select count(*) from sqlite_master where type='trigger' and name='items_fts_insert'`).Scan(&triggers)
+	return triggers > 0, err
+}
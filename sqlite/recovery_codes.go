@@ -0,0 +1,46 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RecoveryCodeRepo is a Sqlite specific implementation of the recovery code repository
+type RecoveryCodeRepo struct {
+	DB *sql.DB
+}
+
+// Replace will delete all recovery codes of an user and insert new ones
+// return an error
+func (repo *RecoveryCodeRepo) Replace(ctx context.Context, userID int, hashes []string) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = exec(ctx, tx, "delete from recovery_codes where userid=?", userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err = exec(ctx, tx, "insert into recovery_codes(userid,hash) values (?,?)", userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Use will mark an unused recovery code of an user as used
+// return an error
+// if the user has no such unused code, return app.ErrNotFound
+func (repo *RecoveryCodeRepo) Use(ctx context.Context, userID int, hash string) error {
+	res, err := exec(ctx, repo.DB, "update recovery_codes set used_at=? where userid=? and hash=? and used_at is null",
+		time.Now().Unix(), userID, hash)
+	if err != nil {
+		return err
+	}
+	return mustAffect(res)
+}
@@ -0,0 +1,60 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	app "useritem"
+)
+
+// TokenRepo is a Sqlite specific implementation of the one-time token repository
+type TokenRepo struct {
+	DB *sql.DB
+}
+
+// Create insert new token into database
+// return an error
+func (repo *TokenRepo) Create(ctx context.Context, token *app.OneTimeToken) error {
+	_, err := exec(ctx, repo.DB, "insert into tokens(hash,kind,userid,expires_at,email) values (?,?,?,?,?)",
+		token.Hash, token.Kind, token.UserID, token.ExpiresAt.Unix(), token.Email)
+	return err
+}
+
+// Consume will mark a token as used so it can never be used again
+// return the consumed *app.OneTimeToken and an error
+// if the token does not exist, is already used or is expired, return app.ErrNotFound
+// if any SQL-specific error happens, pass the error through
+func (repo *TokenRepo) Consume(ctx context.Context, kind app.TokenKind, hash string, now time.Time) (*app.OneTimeToken, error) {
+	res, err := exec(ctx, repo.DB, "update tokens set used_at=? where hash=? and kind=? and used_at is null and expires_at>?",
+		now.Unix(), hash, kind, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, app.ErrNotFound
+	}
+
+	token := app.OneTimeToken{
+		Kind: kind,
+		Hash: hash,
+	}
+	var expiresAt int64
+	row := queryRow(ctx, repo.DB, "select userid, expires_at, email from tokens where hash=?", hash)
+	err = row.Scan(&token.UserID, &expiresAt, &token.Email)
+	if err != nil {
+		return nil, err
+	}
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+	return &token, nil
+}
+
+// DeleteByUser will delete all tokens of a kind that belong to an user
+// return an error
+func (repo *TokenRepo) DeleteByUser(ctx context.Context, kind app.TokenKind, userID int) error {
+	_, err := exec(ctx, repo.DB, "delete from tokens where kind=? and userid=?", kind, userID)
+	return err
+}
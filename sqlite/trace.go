@@ -0,0 +1,70 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("useritem/sqlite")
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// startSpan starts a child span for a query, named after its operation
+func startSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	op := "query"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		op = strings.ToLower(fields[0])
+	}
+	return tracer.Start(ctx, "sqlite "+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.operation", op),
+			attribute.String("db.statement", query),
+		))
+}
+
+// endSpan ends a query span, recording its error if any
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// exec runs a statement in its own span
+func exec(ctx context.Context, q querier, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startSpan(ctx, query)
+	res, err := q.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+// queryRows runs a query returning rows in its own span.
+// The span covers running the query, not reading its rows
+func queryRows(ctx context.Context, q querier, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startSpan(ctx, query)
+	rows, err := q.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+// queryRow runs a query returning at most one row in its own span
+func queryRow(ctx context.Context, q querier, query string, args ...interface{}) *sql.Row {
+	ctx, span := startSpan(ctx, query)
+	row := q.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
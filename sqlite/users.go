@@ -1,73 +1,185 @@
-package sqlite
-
-import (
-	"database/sql"
-	"strings"
-	app "useritem"
-)
-
-// UserRepo is a Sqlite specific implementation of the user repository
-type UserRepo struct {
-	DB *sql.DB
-}
-
-// ByEmail will look for a user with the same email address
-// return *app.User and an error
-// if not found, return app.ErrNotFound
-// if any SQL-specific error happens, pass the error through
-//
-// ByEmail is NOT case sensitive
-func (repo *UserRepo) ByEmail(email string) (*app.User, error) {
-	// prepare user
-	user := app.User{
-		Email: strings.ToLower(email),
-	}
-
-	// query row and get user
-	var password string
-	row := repo.DB.QueryRow("select id, name, password, token from users where email=?", user.Email)
-	err := row.Scan(&user.ID, &user.Name, &password, &user.Token)
-	if err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			return nil, app.ErrNotFound
-		default:
-			return nil, err
-		}
-	}
-	user.SetPassword(password)
-	return &user, nil
-}
-
-// ByToken will look for a user with the same token
-// return *app.User and an error
-// if not found, return app.ErrNotFound
-// if any SQL-specific error happens, pass the error through
-func (repo *UserRepo) ByToken(token int) (*app.User, error) {
-	// prepare user
-	user := app.User{
-		Token: token,
-	}
-
-	// query row and get user
-	var password string
-	row := repo.DB.QueryRow("select id, name, password, email from users where token=?", user.Token)
-	err := row.Scan(&user.ID, &user.Name, &password, &user.Email)
-	if err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			return nil, app.ErrNotFound
-		default:
-			return nil, err
-		}
-	}
-	user.SetPassword(password)
-	return &user, nil
-}
-
-// UpdateToken will update the token of a user with a specific id
-// return an error
-func (repo *UserRepo) UpdateToken(userID int, newToken int) error {
-	_, err := repo.DB.Exec("update users set token=? where id=?", newToken, userID)
-	return err
-}
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	app "useritem"
+)
+
+// userColumns are the columns of users read by scanUser
+const userColumns = "id, name, email, password, coalesce(token, 0), email_verified, role, disabled, totp_secret, totp_enabled, language"
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads a user selected with userColumns
+// if there is no row, return app.ErrNotFound
+func scanUser(row scanner) (*app.User, error) {
+	var user app.User
+	var password string
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &password, &user.Token,
+		&user.EmailVerified, &user.Role, &user.Disabled, &user.TOTPSecret, &user.TOTPEnabled, &user.Language)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, app.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	user.SetPassword(password)
+	return &user, nil
+}
+
+// UserRepo is a Sqlite specific implementation of the user repository
+type UserRepo struct {
+	DB *sql.DB
+}
+
+// Create insert new user into database and set its id
+// return an error
+// if the email is already taken, return app.ErrConflict
+func (repo *UserRepo) Create(ctx context.Context, user *app.User, password string) error {
+	user.Email = strings.ToLower(user.Email)
+	_, err := exec(ctx, repo.DB, "insert into users(id,name,email,password) select coalesce(max(id),0)+1,?,?,? from users",
+		user.Name, user.Email, password)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return app.ErrConflict
+		}
+		return err
+	}
+	user.SetPassword(password)
+	return queryRow(ctx, repo.DB, "select id from users where email=?", user.Email).Scan(&user.ID)
+}
+
+// ByID will look for a user with a specific id
+// return *app.User and an error
+// if not found, return app.ErrNotFound
+// if any SQL-specific error happens, pass the error through
+func (repo *UserRepo) ByID(ctx context.Context, id int) (*app.User, error) {
+	row := queryRow(ctx, repo.DB, "select "+userColumns+" from users where id=?", id)
+	return scanUser(row)
+}
+
+// ByEmail will look for a user with the same email address
+// return *app.User and an error
+// if not found, return app.ErrNotFound
+// if any SQL-specific error happens, pass the error through
+//
+// ByEmail is NOT case sensitive
+func (repo *UserRepo) ByEmail(ctx context.Context, email string) (*app.User, error) {
+	row := queryRow(ctx, repo.DB, "select "+userColumns+" from users where email=?", strings.ToLower(email))
+	return scanUser(row)
+}
+
+// ByToken will look for a user with the same token
+// return *app.User and an error
+// if not found, return app.ErrNotFound
+// if any SQL-specific error happens, pass the error through
+func (repo *UserRepo) ByToken(ctx context.Context, token int) (*app.User, error) {
+	row := queryRow(ctx, repo.DB, "select "+userColumns+" from users where token=?", token)
+	return scanUser(row)
+}
+
+// Search will look for users whose name or email contains a query,
+// ordered by id. An empty query matches every user
+// return slice of app.User and an error
+func (repo *UserRepo) Search(ctx context.Context, query string, limit, offset int) ([]app.User, error) {
+	pattern := "%" + escapeLike(strings.ToLower(query)) + "%"
+	rows, err := queryRows(ctx, repo.DB, "select "+userColumns+" from users"+
+		" where lower(name) like ? escape '\\' or email like ? escape '\\'"+
+		" order by id limit ? offset ?", pattern, pattern, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []app.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// UpdateToken will update the token of a user with a specific id
+// return an error
+func (repo *UserRepo) UpdateToken(ctx context.Context, userID int, newToken int) error {
+	_, err := exec(ctx, repo.DB, "update users set token=? where id=?", newToken, userID)
+	return err
+}
+
+// UpdatePassword will update the password of a user with a specific id
+// return an error
+func (repo *UserRepo) UpdatePassword(ctx context.Context, userID int, password string) error {
+	_, err := exec(ctx, repo.DB, "update users set password=? where id=?", password, userID)
+	return err
+}
+
+// UpdateEmail will change the email of a user with a specific id.
+// The new email is not verified
+// return an error
+// if the email is already taken, return app.ErrConflict
+func (repo *UserRepo) UpdateEmail(ctx context.Context, userID int, email string) error {
+	_, err := exec(ctx, repo.DB, "update users set email=?, email_verified=0 where id=?", strings.ToLower(email), userID)
+	if isUniqueViolation(err) {
+		return app.ErrConflict
+	}
+	return err
+}
+
+// MarkEmailVerified will mark the email of a user with a specific id as verified
+// return an error
+// if the user's email is no longer the given one, return app.ErrNotFound
+func (repo *UserRepo) MarkEmailVerified(ctx context.Context, userID int, email string) error {
+	res, err := exec(ctx, repo.DB, "update users set email_verified=1 where id=? and email=?", userID, strings.ToLower(email))
+	if err != nil {
+		return err
+	}
+	return mustAffect(res)
+}
+
+// UpdateTOTP will update the two-factor authentication settings of a user with a specific id
+// return an error
+func (repo *UserRepo) UpdateTOTP(ctx context.Context, userID int, secret string, enabled bool) error {
+	_, err := exec(ctx, repo.DB, "update users set totp_secret=?, totp_enabled=? where id=?", secret, enabled, userID)
+	return err
+}
+
+// UseTOTPStep will record the time step of the last two-factor code
+// accepted for a user with a specific id.
+// return app.ErrNotFound when that step or a later one was already used
+func (repo *UserRepo) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	res, err := exec(ctx, repo.DB, "update users set totp_step=? where id=? and totp_step<?", step, userID, step)
+	if err != nil {
+		return err
+	}
+	return mustAffect(res)
+}
+
+// UpdateRole will update the role of a user with a specific id
+// return an error
+func (repo *UserRepo) UpdateRole(ctx context.Context, userID int, role app.Role) error {
+	_, err := exec(ctx, repo.DB, "update users set role=? where id=?", role, userID)
+	return err
+}
+
+// UpdateDisabled will disable or enable a user with a specific id
+// return an error
+func (repo *UserRepo) UpdateDisabled(ctx context.Context, userID int, disabled bool) error {
+	_, err := exec(ctx, repo.DB, "update users set disabled=? where id=?", disabled, userID)
+	return err
+}
+
+// UpdateLanguage will update the preferred language of a user with a specific id
+// return an error
+func (repo *UserRepo) UpdateLanguage(ctx context.Context, userID int, language string) error {
+	_, err := exec(ctx, repo.DB, "update users set language=? where id=?", language, userID)
+	return err
+}
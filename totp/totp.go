@@ -0,0 +1,103 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is how long a code stays valid
+	Period = 30 * time.Second
+	// Digits is the length of a code
+	Digits = 6
+	// Skew is the number of periods before and after the current one
+	// whose codes are still accepted, to cope with clock drift
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32 encoded secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Code returns the code of a secret at a given time
+func Code(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("totp: invalid secret: %v", err)
+	}
+	return code(key, uint64(t.Unix())/uint64(Period/time.Second)), nil
+}
+
+// Validate checks a code against a secret at a given time
+func Validate(secret, passcode string, t time.Time) bool {
+	_, ok := ValidateStep(secret, passcode, t)
+	return ok
+}
+
+// ValidateStep checks a code against a secret at a given time
+// and returns the time step the code belongs to, so that callers
+// can refuse a code whose step was already used
+func ValidateStep(secret, passcode string, t time.Time) (int64, bool) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	passcode = strings.Replace(passcode, " ", "", -1)
+	if len(passcode) != Digits {
+		return 0, false
+	}
+	counter := uint64(t.Unix()) / uint64(Period/time.Second)
+	for i := -Skew; i <= Skew; i++ {
+		expected := code(key, counter+uint64(i))
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(passcode)) == 1 {
+			return int64(counter + uint64(i)), true
+		}
+	}
+	return 0, false
+}
+
+// URL returns the otpauth:// URL of a secret,
+// which authenticator apps read from a QR code
+func URL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// code computes a HOTP value (RFC 4226)
+func code(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
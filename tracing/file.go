@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// fileClient is an otlptrace.Client writing spans as OTLP/JSON,
+// a TracesData object per line like the file exporter of the collector
+type fileClient struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (c *fileClient) Start(context.Context) error { return nil }
+
+func (c *fileClient) Stop(context.Context) error { return nil }
+
+func (c *fileClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	b, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(&tracepb.TracesData{ResourceSpans: spans})
+	if err != nil {
+		return err
+	}
+	var data any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err = dec.Decode(&data)
+	if err != nil {
+		return err
+	}
+	hexIDs(data)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(data)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.w.Write(buf.Bytes())
+	return err
+}
+
+// hexIDs encodes the trace and span IDs as hex, as OTLP/JSON wants,
+// where protojson writes them as base64 like any bytes field
+func hexIDs(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			switch k {
+			case "traceId", "spanId", "parentSpanId":
+				s, ok := e.(string)
+				if !ok {
+					continue
+				}
+				id, err := base64.StdEncoding.DecodeString(s)
+				if err == nil {
+					v[k] = hex.EncodeToString(id)
+				}
+			default:
+				hexIDs(e)
+			}
+		}
+	case []any:
+		for _, e := range v {
+			hexIDs(e)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Package tracing sets up OpenTelemetry tracing,
+// exporting spans to an OTLP collector or to a file as OTLP/JSON
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Config tells where spans are exported to.
+// Spans go to every destination set, tracing is off when none is
+type Config struct {
+	ServiceName string
+	// OTLPEndpoint is the host:port of an OTLP/HTTP collector
+	OTLPEndpoint string
+	// OTLPInsecure disables TLS to the collector
+	OTLPInsecure bool
+	// W receives batches of spans as OTLP/JSON, one per line,
+	// which the collector's otlpjsonfile receiver can read back
+	W io.Writer
+}
+
+// Setup installs the global tracer provider and propagator.
+// The returned function flushes pending spans and must be called before exiting
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var opts []sdktrace.TracerProviderOption
+	if cfg.OTLPEndpoint != "" {
+		clientOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	if cfg.W != nil {
+		exporter, err := otlptrace.New(ctx, &fileClient{w: cfg.W})
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	if len(opts) == 0 {
+		// Nowhere to export to, keep the no-op provider
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName)))
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(append(opts, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}